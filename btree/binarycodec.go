@@ -0,0 +1,467 @@
+package btree
+
+import (
+	"bptree/dbmodels"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BinaryCodec writes pages in a compact self-describing layout:
+//
+//	"BPG" version kind                     fixed header
+//	length slots count next previous      varints, followed by parent and offset
+//	parent offset childrenData children   children prefixed by their number
+//	slot directory                         per occupied slot its index and the offset of its entry, 2 + 4 bytes
+//	entries                                key, then value on data pages
+//
+// Keys and values carry a type tag followed by a varint, fixed width float, or length prefixed bytes. Pages, the rows
+// of a key, maps of primary keys to pages, composite keys and the basic kinds of Go are encoded natively. Other types
+// fall back to gob and must be registered with gob.Register, as keys and values of type any already have to be.
+var BinaryCodec PageCodec = binaryCodec{}
+
+func init() {
+	RegisterCodec(BinaryCodec)
+}
+
+const binaryCodecVersion = 1
+
+var binaryCodecMagic = []byte("BPG")
+
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagString
+	tagBytes
+	tagTime
+	tagPage
+	tagPageMap
+	tagGob
+	tagComposite
+	tagRows
+)
+
+var pageKindTags = map[string]byte{DataPageKind: 'd', IndexPageKind: 'i', FreePageKind: 'f'}
+
+// gobValue wraps a value of a type the codec does not know, gob encodes it together with its registered type name.
+type gobValue struct {
+	Value any
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) EncodePage(image *PageImage) ([]byte, error) {
+	kindTag, ok := pageKindTags[image.Kind]
+	if !ok {
+		return nil, fmt.Errorf("cannot encode a %s page", image.Kind)
+	}
+
+	buffer := append(make([]byte, 0, 256), binaryCodecMagic...)
+	buffer = append(buffer, binaryCodecVersion, kindTag)
+	if image.Kind == FreePageKind {
+		return binary.AppendVarint(buffer, int64(image.Next)), nil
+	}
+
+	buffer = binary.AppendUvarint(buffer, uint64(image.Length))
+	buffer = binary.AppendUvarint(buffer, uint64(len(image.Slots)))
+	for _, field := range []int{image.Count, image.Next, image.Previous, image.Parent, image.Offset} {
+		buffer = binary.AppendVarint(buffer, int64(field))
+	}
+	buffer = appendBool(buffer, image.IsChildrenDataPage)
+	buffer = binary.AppendUvarint(buffer, uint64(len(image.Children)))
+	for _, child := range image.Children {
+		buffer = binary.AppendVarint(buffer, int64(child))
+	}
+
+	// The directory is filled in once the entries are written and their offsets known
+	directory := len(buffer)
+	buffer = append(buffer, make([]byte, 6*len(image.Slots))...)
+	entries := len(buffer)
+
+	var err error
+	for i, slot := range image.Slots {
+		if slot.Index > math.MaxUint16 {
+			return nil, fmt.Errorf("slot %d is out of range", slot.Index)
+		}
+		binary.LittleEndian.PutUint16(buffer[directory+6*i:], uint16(slot.Index))
+		binary.LittleEndian.PutUint32(buffer[directory+6*i+2:], uint32(len(buffer)-entries))
+
+		if buffer, err = appendValue(buffer, slot.Key); err != nil {
+			return nil, err
+		}
+		if image.Kind == DataPageKind {
+			if buffer, err = appendValue(buffer, slot.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buffer, nil
+}
+
+func (binaryCodec) DecodePage(data []byte, image *PageImage) error {
+	if !bytes.HasPrefix(data, binaryCodecMagic) || len(data) < len(binaryCodecMagic)+2 {
+		return errors.New("not a binary page")
+	}
+	if version := data[len(binaryCodecMagic)]; version != binaryCodecVersion {
+		return fmt.Errorf("unsupported binary page version %d", version)
+	}
+	kindTag := data[len(binaryCodecMagic)+1]
+	for kind, tag := range pageKindTags {
+		if tag == kindTag {
+			image.Kind = kind
+		}
+	}
+	if image.Kind == "" {
+		return fmt.Errorf("unknown page kind %q", kindTag)
+	}
+
+	reader := &binaryReader{data: data[len(binaryCodecMagic)+2:]}
+	if image.Kind == FreePageKind {
+		image.Next = reader.int()
+		return reader.err
+	}
+
+	if image.Length = int(reader.uvarint()); image.Length > math.MaxUint16 {
+		return fmt.Errorf("container length %d is out of range", image.Length)
+	}
+	slots := reader.length()
+	image.Count, image.Next, image.Previous = reader.int(), reader.int(), reader.int()
+	image.Parent, image.Offset = reader.int(), reader.int()
+	image.IsChildrenDataPage = reader.byte() != 0
+	if children := reader.length(); reader.err == nil && children > 0 {
+		image.Children = make([]int, children)
+		for i := range image.Children {
+			image.Children[i] = reader.int()
+		}
+	}
+
+	directory := reader.bytes(6 * slots)
+	if reader.err != nil {
+		return reader.err
+	}
+	entries := len(reader.data)
+	image.Slots = make([]PageSlot, slots)
+	for i := range image.Slots {
+		slot := &image.Slots[i]
+		slot.Index = int(binary.LittleEndian.Uint16(directory[6*i:]))
+		if offset := int(binary.LittleEndian.Uint32(directory[6*i+2:])); offset != entries-len(reader.data) {
+			return fmt.Errorf("slot %d does not start at its directory offset", slot.Index)
+		}
+
+		slot.Key = reader.value()
+		if image.Kind == DataPageKind {
+			slot.Value = reader.value()
+		}
+	}
+	return reader.err
+}
+
+func appendBool(buffer []byte, value bool) []byte {
+	if value {
+		return append(buffer, 1)
+	}
+	return append(buffer, 0)
+}
+
+func appendBytes(buffer []byte, value []byte) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+func appendPage(buffer []byte, page *dbmodels.Page) []byte {
+	buffer = binary.AppendVarint(buffer, page.DataOffset)
+	return append(buffer, page.FileOffset)
+}
+
+// appendValue appends the type tag and the encoding of a key or value.
+func appendValue(buffer []byte, value any) ([]byte, error) {
+	switch typed := value.(type) {
+	case nil:
+		return append(buffer, tagNil), nil
+	case bool:
+		if typed {
+			return append(buffer, tagTrue), nil
+		}
+		return append(buffer, tagFalse), nil
+	case int:
+		return binary.AppendVarint(append(buffer, tagInt), int64(typed)), nil
+	case int8:
+		return binary.AppendVarint(append(buffer, tagInt8), int64(typed)), nil
+	case int16:
+		return binary.AppendVarint(append(buffer, tagInt16), int64(typed)), nil
+	case int32:
+		return binary.AppendVarint(append(buffer, tagInt32), int64(typed)), nil
+	case int64:
+		return binary.AppendVarint(append(buffer, tagInt64), typed), nil
+	case uint:
+		return binary.AppendUvarint(append(buffer, tagUint), uint64(typed)), nil
+	case uint8:
+		return binary.AppendUvarint(append(buffer, tagUint8), uint64(typed)), nil
+	case uint16:
+		return binary.AppendUvarint(append(buffer, tagUint16), uint64(typed)), nil
+	case uint32:
+		return binary.AppendUvarint(append(buffer, tagUint32), uint64(typed)), nil
+	case uint64:
+		return binary.AppendUvarint(append(buffer, tagUint64), typed), nil
+	case float32:
+		return binary.LittleEndian.AppendUint32(append(buffer, tagFloat32), math.Float32bits(typed)), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(buffer, tagFloat64), math.Float64bits(typed)), nil
+	case string:
+		buffer = binary.AppendUvarint(append(buffer, tagString), uint64(len(typed)))
+		return append(buffer, typed...), nil
+	case []byte:
+		return appendBytes(append(buffer, tagBytes), typed), nil
+	case time.Time:
+		encoded, err := typed.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(append(buffer, tagTime), encoded), nil
+	case *dbmodels.Page:
+		if typed == nil {
+			return append(buffer, tagNil), nil
+		}
+		return appendPage(append(buffer, tagPage), typed), nil
+	case map[any]*dbmodels.Page:
+		buffer = binary.AppendUvarint(append(buffer, tagPageMap), uint64(len(typed)))
+		var err error
+		for primaryKey, page := range typed {
+			if buffer, err = appendValue(buffer, primaryKey); err != nil {
+				return nil, err
+			}
+			if buffer, err = appendValue(buffer, page); err != nil {
+				return nil, err
+			}
+		}
+		return buffer, nil
+	case dbmodels.Rows:
+		buffer = binary.AppendUvarint(append(buffer, tagRows), uint64(len(typed)))
+		var err error
+		for _, row := range typed {
+			if buffer, err = appendValue(buffer, row.PrimaryKey); err != nil {
+				return nil, err
+			}
+			if buffer, err = appendValue(buffer, row.Page); err != nil {
+				return nil, err
+			}
+		}
+		return buffer, nil
+	case CompositeKey:
+		buffer = binary.AppendUvarint(append(buffer, tagComposite), uint64(len(typed)))
+		var err error
+		for _, component := range typed {
+			if buffer, err = appendValue(buffer, component); err != nil {
+				return nil, err
+			}
+		}
+		return buffer, nil
+	default:
+		encoded := new(bytes.Buffer)
+		if err := gob.NewEncoder(encoded).Encode(gobValue{Value: value}); err != nil {
+			return nil, err
+		}
+		return appendBytes(append(buffer, tagGob), encoded.Bytes()), nil
+	}
+}
+
+// EncodeValues encodes values like the keys and values of a binary page, e.g. to hand a position in an index to a
+// client.
+func EncodeValues(values ...any) ([]byte, error) {
+	var buffer []byte
+	var err error
+	for _, value := range values {
+		if buffer, err = appendValue(buffer, value); err != nil {
+			return nil, err
+		}
+	}
+	return buffer, nil
+}
+
+// DecodeValues decodes the values encoded by EncodeValues.
+func DecodeValues(data []byte) ([]any, error) {
+	reader := &binaryReader{data: data}
+	var values []any
+	for len(reader.data) > 0 {
+		values = append(values, reader.value())
+	}
+	return values, reader.err
+}
+
+// binaryReader decodes the fields of a binary page, remembering the first error so that a page is checked once
+// after all its fields are read.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (reader *binaryReader) fail(err error) {
+	if reader.err == nil {
+		reader.err = err
+	}
+	reader.data = nil
+}
+
+func (reader *binaryReader) byte() byte {
+	if len(reader.data) == 0 {
+		reader.fail(errors.New("page is truncated"))
+		return 0
+	}
+	value := reader.data[0]
+	reader.data = reader.data[1:]
+	return value
+}
+
+func (reader *binaryReader) bytes(length int) []byte {
+	if length < 0 || length > len(reader.data) {
+		reader.fail(errors.New("page is truncated"))
+		return nil
+	}
+	value := reader.data[:length:length]
+	reader.data = reader.data[length:]
+	return value
+}
+
+func (reader *binaryReader) varint() int64 {
+	value, n := binary.Varint(reader.data)
+	if n <= 0 {
+		reader.fail(errors.New("invalid varint"))
+		return 0
+	}
+	reader.data = reader.data[n:]
+	return value
+}
+
+func (reader *binaryReader) uvarint() uint64 {
+	value, n := binary.Uvarint(reader.data)
+	if n <= 0 {
+		reader.fail(errors.New("invalid varint"))
+		return 0
+	}
+	reader.data = reader.data[n:]
+	return value
+}
+
+func (reader *binaryReader) int() int {
+	return int(reader.varint())
+}
+
+// length reads the number of bytes or of entries that follow, each entry taking at least a byte.
+func (reader *binaryReader) length() int {
+	value := reader.uvarint()
+	if value > uint64(len(reader.data)) {
+		reader.fail(errors.New("length exceeds the page"))
+		return 0
+	}
+	return int(value)
+}
+
+func (reader *binaryReader) value() any {
+	switch tag := reader.byte(); tag {
+	case tagNil:
+		return nil
+	case tagFalse:
+		return false
+	case tagTrue:
+		return true
+	case tagInt:
+		return int(reader.varint())
+	case tagInt8:
+		return int8(reader.varint())
+	case tagInt16:
+		return int16(reader.varint())
+	case tagInt32:
+		return int32(reader.varint())
+	case tagInt64:
+		return reader.varint()
+	case tagUint:
+		return uint(reader.uvarint())
+	case tagUint8:
+		return uint8(reader.uvarint())
+	case tagUint16:
+		return uint16(reader.uvarint())
+	case tagUint32:
+		return uint32(reader.uvarint())
+	case tagUint64:
+		return reader.uvarint()
+	case tagFloat32:
+		if bits := reader.bytes(4); bits != nil {
+			return math.Float32frombits(binary.LittleEndian.Uint32(bits))
+		}
+		return float32(0)
+	case tagFloat64:
+		if bits := reader.bytes(8); bits != nil {
+			return math.Float64frombits(binary.LittleEndian.Uint64(bits))
+		}
+		return float64(0)
+	case tagString:
+		return string(reader.bytes(reader.length()))
+	case tagBytes:
+		return bytes.Clone(reader.bytes(reader.length()))
+	case tagTime:
+		var value time.Time
+		if err := value.UnmarshalBinary(reader.bytes(reader.length())); err != nil {
+			reader.fail(err)
+		}
+		return value
+	case tagPage:
+		return reader.page()
+	case tagPageMap:
+		pages := make(map[any]*dbmodels.Page)
+		for count := reader.length(); count > 0 && reader.err == nil; count-- {
+			primaryKey := reader.value()
+			page, _ := reader.value().(*dbmodels.Page)
+			pages[primaryKey] = page
+		}
+		return pages
+	case tagRows:
+		rows := make(dbmodels.Rows, 0, reader.length())
+		for count := cap(rows); count > 0 && reader.err == nil; count-- {
+			primaryKey := reader.value()
+			page, _ := reader.value().(*dbmodels.Page)
+			rows = append(rows, dbmodels.Row{PrimaryKey: primaryKey, Page: page})
+		}
+		return rows
+	case tagComposite:
+		key := make(CompositeKey, reader.length())
+		for i := range key {
+			key[i] = reader.value()
+		}
+		return key
+	case tagGob:
+		var value gobValue
+		if err := gob.NewDecoder(bytes.NewReader(reader.bytes(reader.length()))).Decode(&value); err != nil {
+			reader.fail(err)
+		}
+		return value.Value
+	default:
+		reader.fail(fmt.Errorf("unknown type tag %d", tag))
+		return nil
+	}
+}
+
+func (reader *binaryReader) page() *dbmodels.Page {
+	dataOffset := reader.varint()
+	return &dbmodels.Page{DataOffset: dataOffset, FileOffset: reader.byte()}
+}
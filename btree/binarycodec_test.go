@@ -0,0 +1,115 @@
+package btree
+
+import (
+	"bptree/dbmodels"
+	"encoding/gob"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// gobKey is a key of a type the binary codec does not know.
+type gobKey struct {
+	Name string
+}
+
+func init() {
+	gob.Register(gobKey{})
+}
+
+// taggedValues holds a value of every type tag of the binary codec.
+var taggedValues = []struct {
+	tag   byte
+	value any
+}{
+	{tagNil, nil},
+	{tagFalse, false},
+	{tagTrue, true},
+	{tagInt, math.MinInt},
+	{tagInt8, int8(math.MinInt8)},
+	{tagInt16, int16(math.MaxInt16)},
+	{tagInt32, int32(-1)},
+	{tagInt64, int64(math.MaxInt64)},
+	{tagUint, uint(math.MaxUint)},
+	{tagUint8, uint8(math.MaxUint8)},
+	{tagUint16, uint16(1)},
+	{tagUint32, uint32(math.MaxUint32)},
+	{tagUint64, uint64(math.MaxUint64)},
+	{tagFloat32, float32(-1.5)},
+	{tagFloat64, math.Inf(-1)},
+	{tagString, "ключ"},
+	{tagBytes, []byte{0, 1, 255}},
+	{tagTime, time.Date(2024, 2, 29, 12, 30, 0, 1, time.FixedZone("", 3600))},
+	{tagPage, &dbmodels.Page{DataOffset: 1 << 40, FileOffset: 7}},
+	{tagPageMap, map[any]*dbmodels.Page{1: {DataOffset: 1}, "b": {DataOffset: 2}}},
+	{tagGob, gobKey{Name: "gob"}},
+	{tagComposite, CompositeKey{"a", int64(1), CompositeKey{true}}},
+	{tagRows, dbmodels.Rows{{PrimaryKey: 1, Page: &dbmodels.Page{DataOffset: 3}}, {PrimaryKey: "b", Page: &dbmodels.Page{}}}},
+}
+
+// sameValue compares decoded values, times by the instant and offset they record.
+func sameValue(got any, want any) bool {
+	if wantTime, ok := want.(time.Time); ok {
+		gotTime, ok := got.(time.Time)
+		_, gotOffset := gotTime.Zone()
+		_, wantOffset := wantTime.Zone()
+		return ok && gotTime.Equal(wantTime) && gotOffset == wantOffset
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func TestBinaryCodecRoundTripsEveryTag(t *testing.T) {
+	tags := map[byte]bool{}
+	for _, c := range taggedValues {
+		tags[c.tag] = true
+		encoded, err := EncodeValues(c.value)
+		if err != nil {
+			t.Fatalf("%T: %v", c.value, err)
+		}
+		if encoded[0] != c.tag {
+			t.Errorf("%T encoded with tag %d, want %d", c.value, encoded[0], c.tag)
+		}
+		decoded, err := DecodeValues(encoded)
+		if err != nil || len(decoded) != 1 || !sameValue(decoded[0], c.value) {
+			t.Errorf("%T decoded to %#v, %v", c.value, decoded, err)
+		}
+	}
+	if len(tags) != int(tagRows)+1 {
+		t.Fatalf("%d of %d tags covered", len(tags), tagRows+1)
+	}
+}
+
+func TestBinaryCodecRoundTripsPages(t *testing.T) {
+	data := &PageImage{Kind: DataPageKind, Count: len(taggedValues), Length: 2 * len(taggedValues), Next: 3, Previous: 1, Parent: 2, Offset: 4}
+	index := &PageImage{Kind: IndexPageKind, Count: len(taggedValues), Length: len(taggedValues) + 1, IsChildrenDataPage: true, Offset: 2}
+	for i, c := range taggedValues {
+		// Data pages hold every value under every key, in a container with empty slots
+		data.Slots = append(data.Slots, PageSlot{Index: 2 * i, Key: c.value, Value: taggedValues[len(taggedValues)-1-i].value})
+		index.Slots = append(index.Slots, PageSlot{Index: i, Key: c.value})
+		index.Children = append(index.Children, 4096*(i+1))
+	}
+	index.Children = append(index.Children, -1)
+
+	for _, image := range []*PageImage{data, index, {Kind: FreePageKind, Next: 8192}} {
+		encoded, err := BinaryCodec.EncodePage(image)
+		if err != nil {
+			t.Fatal(image.Kind, err)
+		}
+		var decoded PageImage
+		if err = BinaryCodec.DecodePage(encoded, &decoded); err != nil {
+			t.Fatal(image.Kind, err)
+		}
+		got, want := decoded, *image
+		got.Slots, want.Slots = nil, nil
+		if !reflect.DeepEqual(got, want) || len(decoded.Slots) != len(image.Slots) {
+			t.Fatalf("%s page decoded to %+v, want %+v", image.Kind, decoded, image)
+		}
+		for i, slot := range image.Slots {
+			if decodedSlot := decoded.Slots[i]; decodedSlot.Index != slot.Index ||
+				!sameValue(decodedSlot.Key, slot.Key) || !sameValue(decodedSlot.Value, slot.Value) {
+				t.Fatalf("%s page slot %d decoded to %+v, want %+v", image.Kind, i, decodedSlot, slot)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// A page whose encoding outgrows its block bleeds the rest of it into a chain of bleed blocks. Bleed blocks have
+// the size of the block of the page and are allocated from and released to the same free list. Each starts with the offset
+// of the next block of the chain, 0 ending it, followed by the next part of the encoding. The head of the chain is
+// recorded in the frame of the page and kept in the bleedPage field of the decoded page so that the chain can be
+// released when the page is saved again or freed.
+const bleedHeaderLength = 8
+
+func bleedPageOf[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock) int {
+	switch typed := any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		return typed.bleedPage
+	case *IndexPage[TKey, TValue]:
+		return typed.bleedPage
+	default:
+		return 0
+	}
+}
+
+func setBleedPage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock, bleedPage int) {
+	switch typed := any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		typed.bleedPage = bleedPage
+	case *IndexPage[TKey, TValue]:
+		typed.bleedPage = bleedPage
+	}
+}
+
+// writeBleedBlocks writes data into a new chain of bleed blocks of blockSize and returns the offset of its head.
+func (tree *BTree[TKey, TValue]) writeBleedBlocks(data []byte, blockSize int, file *os.File) int {
+	payloadLength := blockSize - bleedHeaderLength
+	offsets := make([]int, (len(data)+payloadLength-1)/payloadLength)
+	for i := range offsets {
+		offsets[i] = tree.allocatePage(blockSize, file)
+	}
+
+	for i, offset := range offsets {
+		next := 0
+		if i+1 < len(offsets) {
+			next = offsets[i+1]
+		}
+		block := make([]byte, blockSize)
+		binary.LittleEndian.PutUint64(block, uint64(next))
+		data = data[copy(block[bleedHeaderLength:], data):]
+
+		if tree.batch != nil {
+			tree.batch.put(offset, block, nil)
+		} else if _, err := file.WriteAt(block, int64(offset)); err != nil {
+			throw(err)
+		}
+	}
+	saveMetadata(tree, file)
+	return offsets[0]
+}
+
+// freeBleedBlocks releases the chain of bleed blocks of blockSize starting at offset, 0 being an empty chain.
+func (tree *BTree[TKey, TValue]) freeBleedBlocks(offset int, blockSize int, file *os.File) {
+	for offset != 0 {
+		block, err := tree.readBleedBlock(file, offset, blockSize)
+		if err != nil {
+			throw(err)
+		}
+		next := int(binary.LittleEndian.Uint64(block))
+		tree.freePage(offset, blockSize, file)
+		offset = next
+	}
+}
+
+// readBleedBlock reads a bleed block, preferring the copy written by the mutation in progress. Bleed blocks are
+// never pooled.
+func (tree *BTree[TKey, TValue]) readBleedBlock(file *os.File, offset int, blockSize int) ([]byte, error) {
+	if block, ok := tree.batch.get(offset); ok {
+		return block, nil
+	}
+	return readBlock(file, offset, blockSize)
+}
+
+func readBlock(file *os.File, offset int, blockSize int) ([]byte, error) {
+	block := make([]byte, blockSize)
+	if _, err := file.ReadAt(block, int64(offset)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return block, nil
+}
+
+// readBleedBlocks appends the chain of bleed blocks starting at offset to data until it holds length bytes or the
+// chain ends.
+func readBleedBlocks(data []byte, offset int, length int, read func(offset int) ([]byte, error)) ([]byte, error) {
+	for len(data) < length && offset != 0 {
+		block, err := read(offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = int(binary.LittleEndian.Uint64(block))
+		data = append(data, block[bleedHeaderLength:min(len(block), bleedHeaderLength+length-len(data))]...)
+	}
+	return data, nil
+}
@@ -0,0 +1,207 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// smallBlocks lays a tree out in the smallest blocks, so that long keys and values bleed.
+var smallBlocks = Options{Order: 6, IndexBlockSize: MinBlockSize, PageBlockSize: MinBlockSize}
+
+// checkEntries fails unless the tree holds exactly want, in order and by Get.
+func checkEntries(t *testing.T, tree *BTree[string, string], file *os.File, want map[string]string) {
+	t.Helper()
+	e, err := tree.SeekFirst(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	count, previous := 0, ""
+	for e.HasNext() {
+		key, value, err := e.Next(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count > 0 && *key <= previous {
+			t.Fatalf("key %.8s after %.8s", *key, previous)
+		}
+		if want[*key] != *value {
+			t.Fatalf("key %.8s holds %d bytes, want %d", *key, len(*value), len(want[*key]))
+		}
+		previous = *key
+		count++
+	}
+	if count != len(want) || tree.Count != len(want) {
+		t.Fatalf("%d entries, count %d, want %d", count, tree.Count, len(want))
+	}
+	for key, value := range want {
+		got, found, err := tree.Get(key, file)
+		if err != nil || !found || *got != value {
+			t.Fatalf("Get %.8s: %v %v", key, found, err)
+		}
+	}
+}
+
+// freeBlocks returns the length of the free list of blocks of blockSize.
+func freeBlocks[TKey, TValue any](t *testing.T, tree *BTree[TKey, TValue], file *os.File, blockSize int) int {
+	t.Helper()
+	blocks := 0
+	for offset := *tree.freeListHead(blockSize); offset != 0; blocks++ {
+		var freePage FreePage
+		if _, err := readPage(tree, &freePage, file, offset, blockSize); err != nil {
+			t.Fatal(err)
+		}
+		offset = freePage.Next
+	}
+	return blocks
+}
+
+func TestOversizedEntriesSurviveSplitsMergesAndReopen(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[string, string](file.Name(), smallBlocks, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	text := func(maxLength int) string { return strings.Repeat(string(rune('a'+r.Intn(26))), r.Intn(maxLength)) }
+
+	want := map[string]string{}
+	for i := 0; i < 300; i++ {
+		// Every third key outgrows an index block on its own, values up to several data blocks
+		key := fmt.Sprintf("%04d", i) + text(20)
+		if i%3 == 0 {
+			key += text(1500)
+		}
+		want[key] = text(2000)
+		if err = tree.Put(key, want[key], file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkEntries(t, tree, file, want)
+
+	// Values replaced by larger and smaller ones split pages again or drop their chains
+	for key := range want {
+		if r.Intn(4) == 0 {
+			want[key] = text(3000)
+			if err = tree.Put(key, want[key], file); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checkEntries(t, tree, file, want)
+
+	deleted := 0
+	for key := range want {
+		if deleted == 200 {
+			break
+		}
+		if found, err := tree.Delete(key, file); err != nil || !found {
+			t.Fatal(key, found, err)
+		}
+		delete(want, key)
+		if deleted++; deleted%50 == 0 {
+			checkEntries(t, tree, file, want)
+		}
+	}
+
+	file.Close()
+	file, err = os.OpenFile(file.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if tree, err = ReadMetadata[string, string](file); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, tree, file, want)
+}
+
+func TestFreedBleedBlocksAreReused(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[string, string](file.Name(), smallBlocks, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 3; key++ {
+		if err = tree.Put(fmt.Sprint(key), "small", file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	large := strings.Repeat("v", 10*MinBlockSize)
+	// The encoding of the value alone takes this many bleed blocks
+	chainLength := len(large) / (MinBlockSize - bleedHeaderLength)
+
+	if err = tree.Put("large", large, file); err != nil {
+		t.Fatal(err)
+	}
+	fileEnd := tree.LatestOffset
+	free := freeBlocks(t, tree, file, MinBlockSize)
+
+	// Shrinking the value releases its chain
+	if err = tree.Put("large", "small", file); err != nil {
+		t.Fatal(err)
+	}
+	if released := freeBlocks(t, tree, file, MinBlockSize) - free; released < chainLength {
+		t.Fatalf("%d blocks released by shrinking the value, want at least %d", released, chainLength)
+	}
+
+	// Growing it again takes the released blocks instead of extending the file
+	if err = tree.Put("large", large, file); err != nil {
+		t.Fatal(err)
+	}
+	if tree.LatestOffset != fileEnd {
+		t.Fatalf("file grew from %d to %d", fileEnd, tree.LatestOffset)
+	}
+
+	free = freeBlocks(t, tree, file, MinBlockSize)
+	if _, err = tree.Delete("large", file); err != nil {
+		t.Fatal(err)
+	}
+	if released := freeBlocks(t, tree, file, MinBlockSize) - free; released < chainLength {
+		t.Fatalf("%d blocks released by deleting the entry, want at least %d", released, chainLength)
+	}
+	checkEntries(t, tree, file, map[string]string{"0": "small", "1": "small", "2": "small"})
+}
+
+func TestSplitPointBalancesEncodedSize(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[string, string](file.Name(), smallBlocks, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("x", 2000)
+
+	for _, c := range []struct {
+		sizes           []int
+		data, indexPage int
+	}{
+		{[]int{2000, 10, 10, 10, 10}, 1, 1},
+		{[]int{10, 10, 10, 10, 2000}, 4, 3},
+		{[]int{10, 2000, 10, 2000, 10}, 2, 2},
+	} {
+		dataPage := &DataPage[string, string]{tree: tree, bleedPage: 1, Count: len(c.sizes)}
+		indexPage := &IndexPage[string, string]{tree: tree, bleedPage: 1, Count: len(c.sizes)}
+		for i, size := range c.sizes {
+			key := fmt.Sprint(i) + large[:size]
+			dataPage.Container = append(dataPage.Container, newDataNode(key, ""))
+			indexPage.Container = append(indexPage.Container, newIndexNode(key))
+		}
+		if at := dataPage.splitPoint(); at != c.data {
+			t.Errorf("data page of %v split at %d, want %d", c.sizes, at, c.data)
+		}
+		// The key at the split point of an index page is pushed up and left out of both halves
+		if at := indexPage.splitPoint(); at != c.indexPage {
+			t.Errorf("index page of %v split at %d, want %d", c.sizes, at, c.indexPage)
+		}
+	}
+
+	// Pages full by count that fit their block split in the middle
+	dataPage := &DataPage[string, string]{tree: tree, Count: 6}
+	if at := dataPage.splitPoint(); at != tree.MidPoint {
+		t.Errorf("page without bleed blocks split at %d", at)
+	}
+}
@@ -1,9 +1,10 @@
 package btree
 
 import (
-	"bptree/utils"
 	"cmp"
+	"fmt"
 	"log"
+	"maps"
 	"math"
 	"os"
 	"slices"
@@ -39,35 +40,87 @@ type BTree[TKey, TValue any] struct {
 	LatestOffset int
 	IsLeaf       bool
 
-	file *os.File
+	// Heads of the free lists of released index and data blocks, 0 when a list is empty
+	FreeIndexPage int
+	FreeDataPage  int
+
+	// Name of the PageCodec the pages are written with, empty when they are gob encoded
+	Codec string
+
+	// Block sizes the tree was created with, zero in metadata written while they were fixed
+	MetadataSize   int
+	IndexBlockSize int
+	PageBlockSize  int
+
+	Attributes map[string]string // See Options
+
+	// Name of the Comparator ordering the keys, empty when they are ordered by utils.Compare
+	Comparator string
+
+	// Components of the keys ordered from the largest to the smallest value, see Options
+	Descending []bool
+
+	codec      PageCodec
+	comparator Comparator
+	file       *os.File
+	batch      *pageBatch // Pages written by the mutation in progress
+	pool       *PagePool
+	snapshot   *Snapshot // Snapshot the pages are read at, nil to read the current pages
 }
 
 func (tree *BTree[TKey, TValue]) IsEmpty() bool {
 	return tree.Count == 0
 }
 
-func binarySearchPage[TKey, TValue any, TTNode TNode[TKey, TValue]](space []TTNode, key TKey) (int, bool) {
+// UsePagePool makes the tree read and write its pages through pool.
+func (tree *BTree[TKey, TValue]) UsePagePool(pool *PagePool) {
+	tree.pool = pool
+}
+
+func binarySearchPage[TKey, TValue any, TTNode TNode[TKey, TValue]](space []TTNode, key TKey, compare func(a, b TKey) int) (int, bool) {
 	return slices.BinarySearchFunc(space, key, func(t1 TTNode, t2 TKey) int {
 		switch x := any(t1).(type) {
 		case DataNode[TKey, TValue]:
 			if !x.Exists {
 				return +1
 			}
-			return utils.Compare(x.Key, t2)
+			return compare(x.Key, t2)
 		case IndexNode[TKey]:
 			if !x.Exists {
 				return +1
 			}
-			return utils.Compare(x.Key, t2)
+			return compare(x.Key, t2)
 		}
 		return -1
 	})
 }
 
-func NewTree[TKey, TValue any](indexName string, order int, file *os.File) *BTree[TKey, TValue] {
+// NewTree creates a tree laid out by options in the empty file whose pages are written with BinaryCodec.
+func NewTree[TKey, TValue any](indexName string, options Options, file *os.File) (*BTree[TKey, TValue], error) {
+	return NewTreeWithCodec[TKey, TValue](indexName, options, BinaryCodec, file)
+}
+
+// NewTreeWithCodec creates a tree laid out by options in the empty file whose pages are written with codec, gob
+// encoded when codec is nil. The codec must be registered for the tree to be read back.
+func NewTreeWithCodec[TKey, TValue any](indexName string, options Options, codec PageCodec, file *os.File) (newTree *BTree[TKey, TValue], err error) {
+	defer catch(&err)
+
+	options = options.withDefaults()
+	if err = options.validate(); err != nil {
+		return nil, err
+	}
+	newTree = newEmptyTree[TKey, TValue](indexName, options, codec)
+	newTree.atomically(file, func() {
+		newDataPage(newTree, file) // Create a leaf data page for inital ops
+	})
+	return newTree, nil
+}
 
-	newTree := &BTree[TKey, TValue]{
-		RootOffset: MetadataSize,
+// newEmptyTree creates the metadata of a tree with valid options without allocating any page.
+func newEmptyTree[TKey, TValue any](indexName string, options Options, codec PageCodec) *BTree[TKey, TValue] {
+	order := options.Order
+	tree := &BTree[TKey, TValue]{
+		RootOffset: options.MetadataSize,
 		IndexName:  indexName,
 		Count:      0,
 		Order:      order,
@@ -80,30 +133,48 @@ func NewTree[TKey, TValue any](indexName string, order int, file *os.File) *BTre
 		MaxIndexCount: order,
 		MinIndexCount: int(math.Ceil(float64(order)/2.0) - 1),
 		IsLeaf:        true,
+
+		LatestOffset: options.MetadataSize,
+
+		MetadataSize:   options.MetadataSize,
+		IndexBlockSize: options.IndexBlockSize,
+		PageBlockSize:  options.PageBlockSize,
+		Attributes:     maps.Clone(options.Attributes),
+
+		Comparator: options.Comparator,
+		comparator: options.comparator(),
+		Descending: slices.Clone(options.Descending),
+	}
+	if codec != nil {
+		tree.Codec = codec.Name()
+		tree.codec = codec
 	}
-	newTree.LatestOffset += MetadataSize
-	newDataPage(newTree, file) // Create a leaf data page for inital ops
-	return newTree
+	return tree
 }
 
 func (tree *BTree[TKey, TValue]) findDataPageFromIndexRoot(key TKey, file *os.File) *DataPage[TKey, TValue] {
+	return tree.findDataPage(key, tree.Compare, file)
+}
+
+// findDataPage descends from the root to the data page holding key when keys are ordered by compare.
+func (tree *BTree[TKey, TValue]) findDataPage(key TKey, compare func(a, b TKey) int, file *os.File) *DataPage[TKey, TValue] {
 	var currentPageOffset int = tree.RootOffset
 
 	if tree.IsLeaf {
-		rootDataPage := ReadDataPage(tree, file, currentPageOffset)
+		rootDataPage := readDataPage(tree, file, currentPageOffset)
 		return rootDataPage
 	}
 
 	for {
-		currentIndexPage := ReadIndexPage(tree, file, currentPageOffset)
-		index, found := binarySearchPage[TKey, TValue](currentIndexPage.Container, key)
+		currentIndexPage := readIndexPage(tree, file, currentPageOffset)
+		index, found := binarySearchPage[TKey, TValue](currentIndexPage.Container, key, compare)
 
 		if currentIndexPage.IsChildrenDataPage {
 			if found {
-				dataPage := ReadDataPage(tree, file, currentIndexPage.Children[index+1])
+				dataPage := readDataPage(tree, file, currentIndexPage.Children[index+1])
 				return dataPage
 			} else {
-				dataPage := ReadDataPage(tree, file, currentIndexPage.Children[index])
+				dataPage := readDataPage(tree, file, currentIndexPage.Children[index])
 				return dataPage
 			}
 		} else {
@@ -136,40 +207,40 @@ func (tree *BTree[TKey, TValue]) findDataPageFromIndexRoot(key TKey, file *os.Fi
 //	}
 //}
 
-func (tree *BTree[TKey, TValue]) insertToLeafNode(dataPage *DataPage[TKey, TValue], key TKey, value TValue, file *os.File) (int, bool /*isOverflowing*/) {
+func (tree *BTree[TKey, TValue]) insertToLeafNode(dataPage *DataPage[TKey, TValue], key TKey, value TValue, file *os.File) (int, bool /*isOverflowing*/, bool /*alreadyExists*/) {
 	_, shouldBeAt, alreadyExists := dataPage.findAndUpdateIfExists(key, file, value)
 
 	if alreadyExists {
-		// TODO Handle Updated
-		return shouldBeAt, false
+		return shouldBeAt, false, true
 	} else {
 		if dataPage.isOverflowing() {
-			return shouldBeAt, true
+			return shouldBeAt, true, false
 		} else {
 			dataPage.insertAt(shouldBeAt, key, value)
-			SaveDataPage(tree, dataPage, file, dataPage.Offset)
-			return shouldBeAt, false
+			saveDataPage(tree, dataPage, file, dataPage.Offset)
+			return shouldBeAt, false, false
 		}
 	}
 }
 
 func (tree *BTree[TKey, TValue]) splitAndPushIndexPage(indexPage *IndexPage[TKey, TValue], file *os.File) *IndexPage[TKey, TValue] {
 	parentOffset := indexPage.Parent
-	newParentKey := indexPage.Container[tree.MidPoint]
+	at := indexPage.splitPoint()
+	newParentKey := indexPage.Container[at]
 
-	newIndexHalf := indexPage.split(file)
-	indexPage.splitChildrenFrom(newIndexHalf, tree)
+	newIndexHalf := indexPage.split(at, file)
+	indexPage.splitChildrenFrom(newIndexHalf, at)
 
 	for _, child := range newIndexHalf.Children {
 		if child != -1 {
 			if newIndexHalf.IsChildrenDataPage {
-				childDataPage := ReadDataPage(tree, file, child)
+				childDataPage := readDataPage(tree, file, child)
 				childDataPage.Parent = newIndexHalf.Offset
-				SaveDataPage(tree, childDataPage, file, childDataPage.Offset)
+				saveDataPage(tree, childDataPage, file, childDataPage.Offset)
 			} else {
-				childIndexPage := ReadIndexPage(tree, file, child)
+				childIndexPage := readIndexPage(tree, file, child)
 				childIndexPage.Parent = newIndexHalf.Offset
-				SaveIndexPage(tree, childIndexPage, file, childIndexPage.Offset)
+				saveIndexPage(tree, childIndexPage, file, childIndexPage.Offset)
 			}
 		} else {
 			break
@@ -178,9 +249,9 @@ func (tree *BTree[TKey, TValue]) splitAndPushIndexPage(indexPage *IndexPage[TKey
 
 	newIndexHalf.Next = indexPage.Next
 	if newIndexHalf.Next != -1 {
-		nextIndexSibling := ReadIndexPage(tree, file, newIndexHalf.Next)
+		nextIndexSibling := readIndexPage(tree, file, newIndexHalf.Next)
 		nextIndexSibling.Previous = newIndexHalf.Offset
-		SaveIndexPage(tree, nextIndexSibling, file, nextIndexSibling.Offset)
+		saveIndexPage(tree, nextIndexSibling, file, nextIndexSibling.Offset)
 	}
 	indexPage.Next = newIndexHalf.Offset
 	newIndexHalf.Previous = indexPage.Offset
@@ -194,22 +265,22 @@ func (tree *BTree[TKey, TValue]) splitAndPushIndexPage(indexPage *IndexPage[TKey
 		indexPage.Parent = parentIndexPage.Offset
 		parentOffset = parentIndexPage.Offset
 		tree.RootOffset = parentIndexPage.Offset
-		SaveMetadata(tree, file)
+		saveMetadata(tree, file)
 	} else {
-		parentIndexPage = ReadIndexPage(tree, file, parentOffset)
+		parentIndexPage = readIndexPage(tree, file, parentOffset)
 		insertedAt, _ := parentIndexPage.insertSorted(newParentKey.Key)
 		parentIndexPage.insertChildAt(insertedAt+1, newIndexHalf.Offset)
 	}
 	newIndexHalf.Parent = parentOffset
-	SaveIndexPage(tree, indexPage, file, indexPage.Offset)
-	SaveIndexPage(tree, parentIndexPage, file, parentIndexPage.Offset)
-	SaveIndexPage(tree, newIndexHalf, file, newIndexHalf.Offset)
+	saveIndexPage(tree, indexPage, file, indexPage.Offset)
+	saveIndexPage(tree, parentIndexPage, file, parentIndexPage.Offset)
+	saveIndexPage(tree, newIndexHalf, file, newIndexHalf.Offset)
 
 	return parentIndexPage
 }
 
 func (tree *BTree[TKey, TValue]) splitAndPushDataPage(dataPage *DataPage[TKey, TValue], file *os.File) *IndexPage[TKey, TValue] {
-	newDataPage := dataPage.split(file)
+	newDataPage := dataPage.split(dataPage.splitPoint(), file)
 
 	var parent *IndexPage[TKey, TValue]
 
@@ -223,33 +294,34 @@ func (tree *BTree[TKey, TValue]) splitAndPushDataPage(dataPage *DataPage[TKey, T
 		parent.insertChildAt(1, newDataPage.Offset) // at 1 will be the new page
 	} else {
 		// If a parent already exists
-		parent = ReadIndexPage(tree, file, dataPage.Parent)
+		parent = readIndexPage(tree, file, dataPage.Parent)
 		newLeafIndex, _ := parent.insertSorted(newDataPage.Container[0].Key) // TODO check how it handles if parent is full
 		if (newLeafIndex + 1) <= 0 {
-			panic("Error here")
+			throw(ErrCorruptPage{Offset: parent.Offset, Kind: IndexPageKind,
+				Reason: fmt.Sprintf("no slot for the keys split off data page %d", dataPage.Offset)})
 		}
 		parent.insertChildAt(newLeafIndex+1, newDataPage.Offset)
 	}
-	SaveIndexPage(tree, parent, file, parent.Offset)
+	saveIndexPage(tree, parent, file, parent.Offset)
 
 	// Set parent for the new page
 	newDataPage.Parent = parent.Offset
 
 	newDataPage.Next = dataPage.Next
 	if newDataPage.Next != -1 {
-		nextDataPage := ReadDataPage(tree, file, newDataPage.Next)
+		nextDataPage := readDataPage(tree, file, newDataPage.Next)
 		nextDataPage.Previous = newDataPage.Offset
-		SaveDataPage(tree, nextDataPage, file, nextDataPage.Offset)
+		saveDataPage(tree, nextDataPage, file, nextDataPage.Offset)
 	}
 
 	dataPage.Next = newDataPage.Offset
 	newDataPage.Previous = dataPage.Offset
-	SaveDataPage(tree, dataPage, file, dataPage.Offset)
-	SaveDataPage(tree, newDataPage, file, newDataPage.Offset)
+	saveDataPage(tree, dataPage, file, dataPage.Offset)
+	saveDataPage(tree, newDataPage, file, newDataPage.Offset)
 
 	currentParent := parent
 	for currentParent != nil {
-		if currentParent.isOverflowing() {
+		if currentParent.isOverflowing() || currentParent.bleeds() {
 			currentParent = tree.splitAndPushIndexPage(currentParent, file)
 		} else {
 			break
@@ -266,19 +338,19 @@ func (tree *BTree[TKey, TValue]) readRelationsOfIndexPage(indexPage *IndexPage[T
 		return nil, nil, nil
 	}
 
-	var parentIndexPage = ReadIndexPage(tree, file, indexPage.Parent)
+	var parentIndexPage = readIndexPage(tree, file, indexPage.Parent)
 	var leftIndexPage *IndexPage[TKey, TValue] = nil
 	var rightIndexPage *IndexPage[TKey, TValue] = nil
 
 	if indexPage.Previous != -1 {
-		leftIndexPage = ReadIndexPage(tree, file, indexPage.Previous)
+		leftIndexPage = readIndexPage(tree, file, indexPage.Previous)
 		if leftIndexPage.Parent != indexPage.Parent {
 			leftIndexPage = nil
 		}
 	}
 
 	if indexPage.Next != -1 {
-		rightIndexPage = ReadIndexPage(tree, file, indexPage.Next)
+		rightIndexPage = readIndexPage(tree, file, indexPage.Next)
 		if rightIndexPage.Parent != indexPage.Parent {
 			rightIndexPage = nil
 		}
@@ -289,19 +361,19 @@ func (tree *BTree[TKey, TValue]) readRelationsOfIndexPage(indexPage *IndexPage[T
 
 func (tree *BTree[TKey, TValue]) readRelationsOfLeafPage(dataPage *DataPage[TKey, TValue], file *os.File) (
 	*IndexPage[TKey, TValue], *DataPage[TKey, TValue], *DataPage[TKey, TValue]) {
-	var parentIndexPage = ReadIndexPage(tree, file, dataPage.Parent)
+	var parentIndexPage = readIndexPage(tree, file, dataPage.Parent)
 	var leftDataPage *DataPage[TKey, TValue] = nil
 	var rightDataPage *DataPage[TKey, TValue] = nil
 
 	if dataPage.Previous != -1 {
-		leftDataPage = ReadDataPage(tree, file, dataPage.Previous)
+		leftDataPage = readDataPage(tree, file, dataPage.Previous)
 		if leftDataPage.Parent != dataPage.Parent {
 			leftDataPage = nil
 		}
 	}
 
 	if dataPage.Next != -1 {
-		rightDataPage = ReadDataPage(tree, file, dataPage.Next)
+		rightDataPage = readDataPage(tree, file, dataPage.Next)
 		if rightDataPage.Parent != dataPage.Parent {
 			rightDataPage = nil
 		}
@@ -310,37 +382,57 @@ func (tree *BTree[TKey, TValue]) readRelationsOfLeafPage(dataPage *DataPage[TKey
 	return parentIndexPage, leftDataPage, rightDataPage
 }
 
-func (tree *BTree[TKey, TValue]) Put(key TKey, value TValue, file *os.File) {
-	if tree.IsLeaf {
-		rootNode := ReadDataPage(tree, file, tree.RootOffset)
-		shouldBeAt, isFull := tree.insertToLeafNode(rootNode, key, value, file)
-		if isFull {
-			rootNode.insertAt(shouldBeAt, key, value)
-			rootPage := tree.splitAndPushDataPage(rootNode, file)
-			tree.RootOffset = rootPage.Offset
-			tree.IsLeaf = false
-		}
-	} else {
-		// Find data page
-		dataPageToInsert := tree.findDataPageFromIndexRoot(key, file)
-		shouldBeAt, isFull := tree.insertToLeafNode(dataPageToInsert, key, value, file)
-		if isFull {
-			dataPageToInsert.insertAt(shouldBeAt, key, value)
-			tree.splitAndPushDataPage(dataPageToInsert, file)
+func (tree *BTree[TKey, TValue]) Put(key TKey, value TValue, file *os.File) (err error) {
+	defer catch(&err)
+
+	tree.atomically(file, func() {
+		tree.put(key, value, file)
+	})
+	return nil
+}
+
+func (tree *BTree[TKey, TValue]) put(key TKey, value TValue, file *os.File) {
+	// Find data page
+	dataPageToInsert := tree.findDataPageFromIndexRoot(key, file)
+	shouldBeAt, isFull, alreadyExists := tree.insertToLeafNode(dataPageToInsert, key, value, file)
+	if alreadyExists {
+		// Updating an existing key does not change the number of entries, but a larger value may outgrow the block
+		if dataPageToInsert.bleeds() {
+			tree.splitDataPage(dataPageToInsert, file)
 		}
+		return
+	}
+
+	if isFull {
+		dataPageToInsert.insertAt(shouldBeAt, key, value)
+		tree.splitDataPage(dataPageToInsert, file)
+	} else if dataPageToInsert.bleeds() {
+		// Large keys or values fill the block before the page is full by count
+		tree.splitDataPage(dataPageToInsert, file)
 	}
 	tree.Count++
-	SaveMetadata(tree, file)
+	saveMetadata(tree, file)
+}
+
+func (tree *BTree[TKey, TValue]) splitDataPage(dataPage *DataPage[TKey, TValue], file *os.File) {
+	parentPage := tree.splitAndPushDataPage(dataPage, file)
+	if tree.IsLeaf {
+		tree.RootOffset = parentPage.Offset
+		tree.IsLeaf = false
+	}
+	saveMetadata(tree, file)
 }
 
-func (tree *BTree[TKey, TValue]) Get(key TKey, file *os.File) (*TValue, bool) {
+func (tree *BTree[TKey, TValue]) Get(key TKey, file *os.File) (value *TValue, found bool, err error) {
+	defer catch(&err)
+
 	dataPage := tree.findDataPageFromIndexRoot(key, file)
-	dataNodeIndex, found := binarySearchPage[TKey, TValue](dataPage.Container, key)
+	dataNodeIndex, found := binarySearchPage[TKey, TValue](dataPage.Container, key, tree.Compare)
 
 	if found {
-		return &dataPage.Container[dataNodeIndex].Value, true
+		return &dataPage.Container[dataNodeIndex].Value, true, nil
 	}
-	return nil, false
+	return nil, false, nil
 }
 
 func (tree *BTree[TKey, TValue]) redistributeIndexPages(leftPage, rightPage *IndexPage[TKey, TValue],
@@ -357,7 +449,7 @@ func (tree *BTree[TKey, TValue]) redistributeIndexPages(leftPage, rightPage *Ind
 			leftPage.deleteChildAt(leftPage.Count - keysToMove + i)
 		}
 		// Update the parent's key value that points to the right page
-		parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key)
+		parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key, tree.Compare)
 		parent.Container[parentKeyIndex].Key = rightPage.Container[0].Key
 	} else {
 		// Calculate the number of keys to move from right to left to balance the pages
@@ -370,22 +462,22 @@ func (tree *BTree[TKey, TValue]) redistributeIndexPages(leftPage, rightPage *Ind
 			rightPage.deleteChildAt(i)
 		}
 		// Update the parent's key value that points to the right page
-		parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key)
+		parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key, tree.Compare)
 		if parentKeyIndex != -1 && parentKeyIndex < parent.Count+1 {
 			parent.Container[parentKeyIndex-1].Key = rightPage.Container[0].Key
 		}
 	}
 
 	// Save changes to both index pages and the parent index page
-	SaveIndexPage(tree, leftPage, file, leftPage.Offset)
-	SaveIndexPage(tree, rightPage, file, rightPage.Offset)
-	SaveIndexPage(tree, parent, file, parent.Offset)
+	saveIndexPage(tree, leftPage, file, leftPage.Offset)
+	saveIndexPage(tree, rightPage, file, rightPage.Offset)
+	saveIndexPage(tree, parent, file, parent.Offset)
 }
 
 func (tree *BTree[TKey, TValue]) redistributeIndexPagesFromLeft(leftPage, rightPage *IndexPage[TKey, TValue],
 	parent *IndexPage[TKey, TValue], file *os.File) {
 	// Move the parent key to the leftPage first
-	parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, leftPage.Container[leftPage.Count-1].Key)
+	parentKeyIndex := parent.childIndexOf(rightPage.Offset) - 1
 
 	copy(rightPage.Container[1:], rightPage.Container[:])
 	rightPage.Container[0] = parent.Container[parentKeyIndex]
@@ -401,15 +493,15 @@ func (tree *BTree[TKey, TValue]) redistributeIndexPagesFromLeft(leftPage, rightP
 	leftPage.deleteAt(leftPage.Count - 1)
 
 	// Persist changes
-	SaveIndexPage(tree, leftPage, file, leftPage.Offset)
-	SaveIndexPage(tree, rightPage, file, rightPage.Offset)
-	SaveIndexPage(tree, parent, file, parent.Offset)
+	saveIndexPage(tree, leftPage, file, leftPage.Offset)
+	saveIndexPage(tree, rightPage, file, rightPage.Offset)
+	saveIndexPage(tree, parent, file, parent.Offset)
 }
 
 func (tree *BTree[TKey, TValue]) redistributeIndexPagesFromRight(leftPage, rightPage *IndexPage[TKey, TValue],
 	parent *IndexPage[TKey, TValue], file *os.File) {
 	// Move the parent key to the leftPage first
-	parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key)
+	parentKeyIndex := parent.childIndexOf(rightPage.Offset)
 	if parentKeyIndex > 0 {
 		leftPage.Container[leftPage.Count] = parent.Container[parentKeyIndex-1]
 		leftPage.Children[leftPage.Count+1] = rightPage.Children[0]
@@ -419,42 +511,38 @@ func (tree *BTree[TKey, TValue]) redistributeIndexPagesFromRight(leftPage, right
 		parent.Container[parentKeyIndex-1] = rightPage.Container[0]
 
 		rightPage.deleteAtIndexAndSort(0)
-		copy(rightPage.Children[:], rightPage.Children[1:])
+		rightPage.deleteChildAtIndexAndSort(0)
 	}
 
 	// Persist changes
-	SaveIndexPage(tree, leftPage, file, leftPage.Offset)
-	SaveIndexPage(tree, rightPage, file, rightPage.Offset)
-	SaveIndexPage(tree, parent, file, parent.Offset)
+	saveIndexPage(tree, leftPage, file, leftPage.Offset)
+	saveIndexPage(tree, rightPage, file, rightPage.Offset)
+	saveIndexPage(tree, parent, file, parent.Offset)
 }
 
 func (tree *BTree[TKey, TValue]) updateChildren(toParentPage, fromParentPage *IndexPage[TKey, TValue], childIndex int, file *os.File) {
 	if fromParentPage.IsChildrenDataPage {
-		childDataPage := ReadDataPage(tree, file, fromParentPage.Children[childIndex])
+		childDataPage := readDataPage(tree, file, fromParentPage.Children[childIndex])
 		childDataPage.Parent = toParentPage.Offset
-		SaveDataPage(tree, childDataPage, file, childDataPage.Offset)
+		saveDataPage(tree, childDataPage, file, childDataPage.Offset)
 	} else {
-		childIndexPage := ReadIndexPage(tree, file, fromParentPage.Children[childIndex])
+		childIndexPage := readIndexPage(tree, file, fromParentPage.Children[childIndex])
 		childIndexPage.Parent = toParentPage.Offset
-		SaveIndexPage(tree, childIndexPage, file, childIndexPage.Offset)
+		saveIndexPage(tree, childIndexPage, file, childIndexPage.Offset)
 	}
 }
 
 func (tree *BTree[TKey, TValue]) updateParentAfterMerge(parentPage *IndexPage[TKey, TValue],
-	childKey TKey, isFromLeft bool, file *os.File) TKey {
-	var keyIndex int
-	keyIndex, _ = binarySearchPage[TKey, TValue](parentPage.Container, childKey)
-	if !isFromLeft {
-		keyIndex--
-	}
+	rightPage *IndexPage[TKey, TValue], file *os.File) TKey {
+	// The separator between the merged pages sits just before the right page's child pointer
+	keyIndex := parentPage.childIndexOf(rightPage.Offset) - 1
 
 	borrowedKey := parentPage.Container[keyIndex].Key
-	copy(parentPage.Container[keyIndex:], parentPage.Container[keyIndex+1:])
-	copy(parentPage.Children[keyIndex+1:], parentPage.Children[keyIndex+2:])
-	parentPage.Count--
+	parentPage.deleteAtIndexAndSort(keyIndex)
+	parentPage.deleteChildAtIndexAndSort(keyIndex + 1)
 
 	// Save the updated parent page
-	SaveIndexPage(tree, parentPage, file, parentPage.Offset)
+	saveIndexPage(tree, parentPage, file, parentPage.Offset)
 
 	return borrowedKey
 }
@@ -478,15 +566,15 @@ func (tree *BTree[TKey, TValue]) mergeIndexPages(leftPage, rightPage *IndexPage[
 	}
 
 	if rightPage.Next != -1 {
-		nextRightPage := ReadIndexPage(tree, file, rightPage.Next)
+		nextRightPage := readIndexPage(tree, file, rightPage.Next)
 		nextRightPage.Previous = leftPage.Offset
 		leftPage.Next = rightPage.Next
-		SaveIndexPage(tree, nextRightPage, file, nextRightPage.Offset)
+		saveIndexPage(tree, nextRightPage, file, nextRightPage.Offset)
 	} else {
 		leftPage.Next = -1 // Right page was the last one
 	}
-	SaveIndexPage(tree, leftPage, file, leftPage.Offset)
-	//TODO  Free right page
+	saveIndexPage(tree, leftPage, file, leftPage.Offset)
+	tree.freeIndexPage(rightPage, file)
 }
 
 func (tree *BTree[TKey, TValue]) handleIndexPageUnderflow(indexPage *IndexPage[TKey, TValue], file *os.File) {
@@ -496,13 +584,21 @@ func (tree *BTree[TKey, TValue]) handleIndexPageUnderflow(indexPage *IndexPage[T
 	if parent == nil {
 		if indexPage.Count == 0 {
 			if indexPage.IsChildrenDataPage {
-				tree.RootOffset = indexPage.Children[0]
+				childDataPage := readDataPage(tree, file, indexPage.Children[0])
+				childDataPage.Parent = -1
+				saveDataPage(tree, childDataPage, file, childDataPage.Offset)
+				tree.RootOffset = childDataPage.Offset
 				tree.IsLeaf = true
 			} else {
-				childIndexPage := ReadIndexPage(tree, file, indexPage.Children[0])
+				childIndexPage := readIndexPage(tree, file, indexPage.Children[0])
+				childIndexPage.Parent = -1
+				saveIndexPage(tree, childIndexPage, file, childIndexPage.Offset)
 				tree.RootOffset = childIndexPage.Offset
 				tree.IsLeaf = false
 			}
+			tree.freeIndexPage(indexPage, file)
+		} else {
+			saveIndexPage(tree, indexPage, file, indexPage.Offset)
 		}
 		return
 	}
@@ -512,10 +608,10 @@ func (tree *BTree[TKey, TValue]) handleIndexPageUnderflow(indexPage *IndexPage[T
 	} else if rightSibling != nil && rightSibling.isLendable() {
 		tree.redistributeIndexPagesFromRight(indexPage, rightSibling, parent, file)
 	} else if leftSibling != nil {
-		key := tree.updateParentAfterMerge(parent, leftSibling.Container[0].Key, true, file)
+		key := tree.updateParentAfterMerge(parent, indexPage, file)
 		tree.mergeIndexPages(leftSibling, indexPage, key, file)
 	} else if rightSibling != nil {
-		key := tree.updateParentAfterMerge(parent, rightSibling.Container[0].Key, false, file)
+		key := tree.updateParentAfterMerge(parent, rightSibling, file)
 		tree.mergeIndexPages(indexPage, rightSibling, key, file)
 	}
 
@@ -568,13 +664,13 @@ func (tree *BTree[TKey, TValue]) redistributeLeafPagesFromLeft(leftPage, rightPa
 	rightPage.Count++
 
 	// Step 3: Update parent key to reflect the new smallest key in the right page
-	parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key)
-	parent.Container[parentKeyIndex].Key = rightPage.Container[0].Key
+	parentKeyIndex := parent.childIndexOf(rightPage.Offset)
+	parent.Container[parentKeyIndex-1].Key = rightPage.Container[0].Key
 
 	// Step 4: Persist changes
-	SaveDataPage(tree, leftPage, file, leftPage.Offset)
-	SaveDataPage(tree, rightPage, file, rightPage.Offset)
-	SaveIndexPage(tree, parent, file, parent.Offset)
+	saveDataPage(tree, leftPage, file, leftPage.Offset)
+	saveDataPage(tree, rightPage, file, rightPage.Offset)
+	saveIndexPage(tree, parent, file, parent.Offset)
 }
 
 func (tree *BTree[TKey, TValue]) redistributeLeafPagesFromRight(leftPage, rightPage *DataPage[TKey, TValue],
@@ -582,20 +678,19 @@ func (tree *BTree[TKey, TValue]) redistributeLeafPagesFromRight(leftPage, rightP
 	leftPage.Container[leftPage.Count] = rightPage.Container[0]
 	// Adjust counts
 	leftPage.Count++
-	rightPage.Count--
 	// Shift keys in rightPage to remove the moved keys
-	copy(rightPage.Container[:], rightPage.Container[1:])
+	rightPage.deleteAtIndexAndSort(0)
 
 	// Step 3: Update parent key to reflect the new smallest key in the right page
-	parentKeyIndex, _ := binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key)
+	parentKeyIndex := parent.childIndexOf(rightPage.Offset)
 	if parentKeyIndex > 0 {
 		parent.Container[parentKeyIndex-1].Key = rightPage.Container[0].Key
 	}
 
 	// Step 4: Persist changes
-	SaveDataPage(tree, leftPage, file, leftPage.Offset)
-	SaveDataPage(tree, rightPage, file, rightPage.Offset)
-	SaveIndexPage(tree, parent, file, parent.Offset)
+	saveDataPage(tree, leftPage, file, leftPage.Offset)
+	saveDataPage(tree, rightPage, file, rightPage.Offset)
+	saveIndexPage(tree, parent, file, parent.Offset)
 }
 
 func (tree *BTree[TKey, TValue]) mergeLeafPages(leftPage, rightPage *DataPage[TKey, TValue],
@@ -608,40 +703,32 @@ func (tree *BTree[TKey, TValue]) mergeLeafPages(leftPage, rightPage *DataPage[TK
 
 	// Step 2: Update sibling links
 	if rightPage.Next != -1 {
-		nextRightPage := ReadDataPage(tree, file, rightPage.Next)
+		nextRightPage := readDataPage(tree, file, rightPage.Next)
 		nextRightPage.Previous = leftPage.Offset
 		leftPage.Next = rightPage.Next
-		SaveDataPage(tree, nextRightPage, file, nextRightPage.Offset)
+		saveDataPage(tree, nextRightPage, file, nextRightPage.Offset)
 	} else {
 		leftPage.Next = -1 // Right page was the last one
 	}
 
 	// Step 3: Remove right page
-	// TODO FreeDataPage(index, rightPage.DataOffset)
+	tree.freeDataPage(rightPage, file)
 
-	// Step 4: Update parent
-	var parentKeyIndex int
-	if rightPage.Count > 0 {
-		parentKeyIndex, _ = binarySearchPage[TKey, TValue](parent.Container, rightPage.Container[0].Key)
-	} else {
-		// Find the key in the parent that points to the rightPage by using the leftPage
-		parentKeyIndex, _ = binarySearchPage[TKey, TValue](parent.Container, leftPage.Container[0].Key)
-		parentKeyIndex++ // Increment to get the index of the key that pointed to rightPage
-	}
+	// Step 4: Update parent, dropping the key that pointed to the rightPage
+	parentKeyIndex := parent.childIndexOf(rightPage.Offset) - 1
 
-	copy(parent.Container[parentKeyIndex:], parent.Container[parentKeyIndex+1:])
-	copy(parent.Children[parentKeyIndex+1:], parent.Children[parentKeyIndex+2:])
-	parent.Count--
+	parent.deleteAtIndexAndSort(parentKeyIndex)
+	parent.deleteChildAtIndexAndSort(parentKeyIndex + 1)
 
 	// Step 5: Save changes
-	SaveDataPage(tree, leftPage, file, leftPage.Offset)
-	//SaveIndexPage(index, parent, parent.DataOffset)
+	saveDataPage(tree, leftPage, file, leftPage.Offset)
+	//saveIndexPage(index, parent, parent.DataOffset)
 
 	if parent.isDeficient() {
 		// Handle underflow for the parent index page
 		tree.handleIndexPageUnderflow(parent, file)
 	} else {
-		SaveIndexPage(tree, parent, file, parent.Offset)
+		saveIndexPage(tree, parent, file, parent.Offset)
 	}
 }
 
@@ -650,12 +737,12 @@ func (tree *BTree[TKey, TValue]) updateIfPresentInInternalPage(dataPage *DataPag
 	currentPageOffset := dataPage.Parent
 
 	for currentPageOffset != -1 {
-		currentIndexPage := ReadIndexPage(tree, file, currentPageOffset)
-		index, found := binarySearchPage[TKey, TValue](currentIndexPage.Container, key)
+		currentIndexPage := readIndexPage(tree, file, currentPageOffset)
+		index, found := binarySearchPage[TKey, TValue](currentIndexPage.Container, key, tree.Compare)
 
 		if found {
 			currentIndexPage.Container[index].Key = inOrderKey
-			SaveIndexPage(tree, currentIndexPage, file, currentIndexPage.Offset)
+			saveIndexPage(tree, currentIndexPage, file, currentIndexPage.Offset)
 		}
 		currentPageOffset = currentIndexPage.Parent
 	}
@@ -668,7 +755,7 @@ func (tree *BTree[TKey, TValue]) getInOrderSuccessor(dataNodeIndex int,
 	}
 
 	if page.Next != -1 {
-		nextPage := ReadDataPage(tree, file, page.Next)
+		nextPage := readDataPage(tree, file, page.Next)
 		return &nextPage.Container[0].Key
 	}
 	return nil
@@ -689,17 +776,26 @@ func (tree *BTree[TKey, TValue]) deleteFromDataPageAndPropagate(dataNodeIndex in
 	if dataPage.Parent != -1 && dataPage.isDeficient() {
 		tree.handleUnderflow(dataPage, key, file)
 	} else {
-		SaveDataPage(tree, dataPage, file, dataPage.Offset)
+		saveDataPage(tree, dataPage, file, dataPage.Offset)
 	}
 }
 
-func (tree *BTree[TKey, TValue]) Delete(key TKey, file *os.File) (ok bool) {
+func (tree *BTree[TKey, TValue]) Delete(key TKey, file *os.File) (ok bool, err error) {
+	defer catch(&err)
+
+	tree.atomically(file, func() {
+		ok = tree.delete(key, file)
+	})
+	return ok, nil
+}
+
+func (tree *BTree[TKey, TValue]) delete(key TKey, file *os.File) bool {
 	if tree.Count == 0 {
 		return false
 	}
 
 	dataPage := tree.findDataPageFromIndexRoot(key, file)
-	dataNodeIndex, found := binarySearchPage[TKey, TValue](dataPage.Container, key)
+	dataNodeIndex, found := binarySearchPage[TKey, TValue](dataPage.Container, key, tree.Compare)
 	if !found {
 		return false
 	}
@@ -712,33 +808,58 @@ func (tree *BTree[TKey, TValue]) Delete(key TKey, file *os.File) (ok bool) {
 
 	// Update index
 	tree.Count--
-	SaveMetadata(tree, file)
+	saveMetadata(tree, file)
 	return true
 }
 
-func (tree *BTree[TKey, TValue]) Seek(key TKey, file *os.File) *Enumerator[TKey, TValue] {
+func (tree *BTree[TKey, TValue]) Seek(key TKey, file *os.File) (enumerator *Enumerator[TKey, TValue], err error) {
+	defer catch(&err)
+
 	dataPage := tree.findDataPageFromIndexRoot(key, file)
-	dataNodeIndex, found := binarySearchPage[TKey, TValue](dataPage.Container, key)
+	dataNodeIndex, found := binarySearchPage[TKey, TValue](dataPage.Container, key, tree.Compare)
 	return &Enumerator[TKey, TValue]{
 		originalKeyFound: found,
 		dataPage:         dataPage,
 		tree:             tree,
 		i:                dataNodeIndex - 1,
+	}, nil
+}
+
+// SeekAfter returns an enumerator positioned after the last key starting with key, see ComparePrefix: Previous
+// returns that key and Next the first key beyond it.
+func (tree *BTree[TKey, TValue]) SeekAfter(key TKey, file *os.File) (enumerator *Enumerator[TKey, TValue], err error) {
+	defer catch(&err)
+
+	// Keys starting with key sort before it, so the search ends after the last of them
+	beyond := func(a, b TKey) int {
+		if tree.ComparePrefix(a, b) <= 0 {
+			return -1
+		}
+		return +1
 	}
+	dataPage := tree.findDataPage(key, beyond, file)
+	dataNodeIndex, _ := binarySearchPage[TKey, TValue](dataPage.Container, key, beyond)
+	return &Enumerator[TKey, TValue]{
+		dataPage: dataPage,
+		tree:     tree,
+		i:        dataNodeIndex - 1,
+	}, nil
 }
 
-func (tree *BTree[TKey, TValue]) SeekFirst(file *os.File) *Enumerator[TKey, TValue] {
+func (tree *BTree[TKey, TValue]) SeekFirst(file *os.File) (enumerator *Enumerator[TKey, TValue], err error) {
+	defer catch(&err)
+
 	var currentPageOffset int = tree.RootOffset
 
 	var firstDataPage *DataPage[TKey, TValue]
 
 	if tree.IsLeaf {
-		firstDataPage = ReadDataPage(tree, file, currentPageOffset)
+		firstDataPage = readDataPage(tree, file, currentPageOffset)
 	} else {
 		for {
-			currentIndexPage := ReadIndexPage(tree, file, currentPageOffset)
+			currentIndexPage := readIndexPage(tree, file, currentPageOffset)
 			if currentIndexPage.IsChildrenDataPage {
-				firstDataPage = ReadDataPage(tree, file, currentIndexPage.Children[0])
+				firstDataPage = readDataPage(tree, file, currentIndexPage.Children[0])
 				break
 			} else {
 				currentPageOffset = currentIndexPage.Children[0]
@@ -751,24 +872,26 @@ func (tree *BTree[TKey, TValue]) SeekFirst(file *os.File) *Enumerator[TKey, TVal
 		dataPage:         firstDataPage,
 		tree:             tree,
 		i:                -1,
-	}
+	}, nil
 }
 
-func (tree *BTree[TKey, TValue]) SeekLast(file *os.File) *Enumerator[TKey, TValue] {
+func (tree *BTree[TKey, TValue]) SeekLast(file *os.File) (enumerator *Enumerator[TKey, TValue], err error) {
+	defer catch(&err)
+
 	var currentPageOffset int = tree.RootOffset
 
 	var lastDataPage *DataPage[TKey, TValue]
 
 	if tree.IsLeaf {
-		lastDataPage = ReadDataPage(tree, file, currentPageOffset)
+		lastDataPage = readDataPage(tree, file, currentPageOffset)
 	} else {
 		for {
-			currentIndexPage := ReadIndexPage(tree, file, currentPageOffset)
+			currentIndexPage := readIndexPage(tree, file, currentPageOffset)
 			if currentIndexPage.IsChildrenDataPage {
-				lastDataPage = ReadDataPage(tree, file, currentIndexPage.Children[currentIndexPage.Count+1])
+				lastDataPage = readDataPage(tree, file, currentIndexPage.Children[currentIndexPage.Count])
 				break
 			} else {
-				currentPageOffset = currentIndexPage.Children[currentIndexPage.Count+1]
+				currentPageOffset = currentIndexPage.Children[currentIndexPage.Count]
 			}
 		}
 	}
@@ -778,5 +901,5 @@ func (tree *BTree[TKey, TValue]) SeekLast(file *os.File) *Enumerator[TKey, TValu
 		dataPage:         lastDataPage,
 		tree:             tree,
 		i:                lastDataPage.Count - 1,
-	}
+	}, nil
 }
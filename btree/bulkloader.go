@@ -0,0 +1,245 @@
+package btree
+
+import (
+	"fmt"
+	"os"
+)
+
+// BulkIterator yields the entries of a bulk load in ascending key order.
+type BulkIterator[TKey, TValue any] interface {
+	HasNext() bool
+	Next() (TKey, TValue, error)
+}
+
+// BulkLoad builds a tree laid out by options bottom-up in the empty file from the entries yielded by entries,
+// writing every page once instead of splitting its way there. Pages are written with codec, gob encoded when codec
+// is nil. Leaves and index pages are filled to fillFactor of their capacity, 1 packs them completely and leaves no
+// room for later puts. The file is synced before the tree is returned. ErrUnsorted is returned when the keys are
+// not unique and ascending.
+func BulkLoad[TKey, TValue any](indexName string, options Options, codec PageCodec, file *os.File,
+	entries BulkIterator[TKey, TValue], fillFactor float64) (tree *BTree[TKey, TValue], err error) {
+	defer catch(&err)
+
+	if fillFactor <= 0 || fillFactor > 1 {
+		return nil, fmt.Errorf("bulk load fill factor %v is not within (0, 1]", fillFactor)
+	}
+	options = options.withDefaults()
+	if err = options.validate(); err != nil {
+		return nil, err
+	}
+
+	tree = newEmptyTree[TKey, TValue](indexName, options, codec)
+	leafFill, indexFill := fillCounts(tree, fillFactor)
+	loader := newBulkLoader(tree, file, leafFill, indexFill)
+	for entries.HasNext() {
+		key, value, err := entries.Next()
+		if err != nil {
+			return nil, err
+		}
+		loader.add(key, value)
+	}
+	loader.finish()
+
+	if err = file.Sync(); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// fillCounts converts a fill factor into the entries per leaf and the children per index page of tree, keeping
+// both within what a page holds without being deficient.
+func fillCounts[TKey, TValue any](tree *BTree[TKey, TValue], fillFactor float64) (int, int) {
+	leafFill := int(fillFactor * float64(tree.MaxLeafCount))
+	leafFill = min(max(leafFill, tree.MinLeafCount, 1), tree.MaxLeafCount)
+
+	indexFill := int(fillFactor * float64(tree.Order))
+	indexFill = min(max(indexFill, tree.MinIndexCount+1, 2), tree.Order)
+	return leafFill, indexFill
+}
+
+// bulkLoader builds a tree bottom-up from entries added in ascending key order. Leaves are cut every leafFill
+// entries and index pages every indexFill children. Each level buffers up to two pages worth of children so that
+// the parent and sibling offsets of a page are known before it is written and the last pages of a level can be
+// balanced instead of left deficient. Every page is written exactly once.
+type bulkLoader[TKey, TValue any] struct {
+	tree      *BTree[TKey, TValue]
+	file      *os.File
+	leafFill  int
+	indexFill int
+
+	lastKey TKey                             // last key added, to reject unsorted input
+	pending []DataNode[TKey, TValue]         // entries not cut into a leaf yet
+	leaves  []*DataPage[TKey, TValue]        // leaves waiting for their parent
+	levels  [][]*bulkIndexPage[TKey, TValue] // index pages waiting for their parent, per level
+}
+
+type bulkIndexPage[TKey, TValue any] struct {
+	page   *IndexPage[TKey, TValue]
+	lowKey TKey // smallest key under the page, pushed up as the separator
+}
+
+func newBulkLoader[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, leafFill, indexFill int) *bulkLoader[TKey, TValue] {
+	return &bulkLoader[TKey, TValue]{
+		tree:      tree,
+		file:      file,
+		leafFill:  leafFill,
+		indexFill: indexFill,
+	}
+}
+
+func (loader *bulkLoader[TKey, TValue]) add(key TKey, value TValue) {
+	if loader.tree.Count > 0 && loader.tree.Compare(loader.lastKey, key) >= 0 {
+		throw(ErrUnsorted)
+	}
+	loader.lastKey = key
+
+	loader.pending = append(loader.pending, newDataNode(key, value))
+	loader.tree.Count++
+	if len(loader.pending) == 2*loader.leafFill {
+		loader.cutLeaf(loader.leafFill)
+	}
+}
+
+func (loader *bulkLoader[TKey, TValue]) cutLeaf(count int) {
+	tree := loader.tree
+	leaf := &DataPage[TKey, TValue]{
+		tree:      tree,
+		Container: make([]DataNode[TKey, TValue], tree.LeafLength),
+		Parent:    -1,
+		Next:      -1,
+		Previous:  -1,
+		Offset:    tree.allocatePage(tree.PageBlockSize, loader.file),
+	}
+	leaf.Count = copy(leaf.Container, loader.pending[:count])
+	loader.pending = append(loader.pending[:0], loader.pending[count:]...)
+
+	if len(loader.leaves) > 0 {
+		previous := loader.leaves[len(loader.leaves)-1]
+		previous.Next = leaf.Offset
+		leaf.Previous = previous.Offset
+	}
+	loader.leaves = append(loader.leaves, leaf)
+	if len(loader.leaves) == 2*loader.indexFill {
+		loader.cutLeafParent(loader.indexFill)
+	}
+}
+
+func (loader *bulkLoader[TKey, TValue]) cutLeafParent(count int) {
+	children := loader.leaves[:count]
+	parent := loader.newParent(0, true, children[0].Container[0].Key)
+	for i, child := range children {
+		if i > 0 {
+			parent.page.insertAt(i-1, child.Container[0].Key)
+		}
+		parent.page.Children[i] = child.Offset
+		child.Parent = parent.page.Offset
+		saveDataPage(loader.tree, child, loader.file, child.Offset)
+	}
+	loader.leaves = append(loader.leaves[:0], loader.leaves[count:]...)
+	loader.pushParent(0, parent)
+}
+
+func (loader *bulkLoader[TKey, TValue]) cutIndexParent(level int, count int) {
+	children := loader.levels[level][:count]
+	parent := loader.newParent(level+1, false, children[0].lowKey)
+	for i, child := range children {
+		if i > 0 {
+			parent.page.insertAt(i-1, child.lowKey)
+		}
+		parent.page.Children[i] = child.page.Offset
+		child.page.Parent = parent.page.Offset
+		saveIndexPage(loader.tree, child.page, loader.file, child.page.Offset)
+	}
+	loader.levels[level] = append(loader.levels[level][:0], loader.levels[level][count:]...)
+	loader.pushParent(level+1, parent)
+}
+
+func (loader *bulkLoader[TKey, TValue]) newParent(level int, isChildrenDataPage bool, lowKey TKey) *bulkIndexPage[TKey, TValue] {
+	tree := loader.tree
+	page := &IndexPage[TKey, TValue]{
+		tree:               tree,
+		Container:          make([]IndexNode[TKey], tree.Order),
+		Children:           make([]int, tree.Order+1),
+		IsChildrenDataPage: isChildrenDataPage,
+		Parent:             -1,
+		Next:               -1,
+		Previous:           -1,
+		Offset:             tree.allocatePage(tree.IndexBlockSize, loader.file),
+	}
+	for i := range page.Children {
+		page.Children[i] = -1
+	}
+
+	if len(loader.levels) == level {
+		loader.levels = append(loader.levels, nil)
+	}
+	if siblings := loader.levels[level]; len(siblings) > 0 {
+		previous := siblings[len(siblings)-1].page
+		previous.Next = page.Offset
+		page.Previous = previous.Offset
+	}
+	return &bulkIndexPage[TKey, TValue]{page: page, lowKey: lowKey}
+}
+
+func (loader *bulkLoader[TKey, TValue]) pushParent(level int, parent *bulkIndexPage[TKey, TValue]) {
+	loader.levels[level] = append(loader.levels[level], parent)
+	if len(loader.levels[level]) == 2*loader.indexFill {
+		loader.cutIndexParent(level, loader.indexFill)
+	}
+}
+
+// remainderSplit tells how many of the remaining count children go into the first of the last pages of a level,
+// a single page is used whenever they fit in one.
+func remainderSplit(count int, capacity int) int {
+	if count <= capacity {
+		return count
+	}
+	return (count + 1) / 2
+}
+
+// finish cuts the remaining entries and pages bottom-up, writes the root and the metadata of the tree.
+func (loader *bulkLoader[TKey, TValue]) finish() *BTree[TKey, TValue] {
+	tree := loader.tree
+
+	if len(loader.pending) > 0 || len(loader.leaves) == 0 {
+		first := remainderSplit(len(loader.pending), tree.MaxLeafCount)
+		loader.cutLeaf(first)
+		if len(loader.pending) > 0 {
+			loader.cutLeaf(len(loader.pending))
+		}
+	}
+
+	if len(loader.levels) == 0 && len(loader.leaves) == 1 {
+		root := loader.leaves[0]
+		saveDataPage(tree, root, loader.file, root.Offset)
+		tree.RootOffset = root.Offset
+		tree.IsLeaf = true
+		saveMetadata(tree, loader.file)
+		return tree
+	}
+
+	first := remainderSplit(len(loader.leaves), tree.Order)
+	loader.cutLeafParent(first)
+	if len(loader.leaves) > 0 {
+		loader.cutLeafParent(len(loader.leaves))
+	}
+
+	for level := 0; ; level++ {
+		if level == len(loader.levels)-1 && len(loader.levels[level]) == 1 {
+			root := loader.levels[level][0].page
+			saveIndexPage(tree, root, loader.file, root.Offset)
+			tree.RootOffset = root.Offset
+			tree.IsLeaf = false
+			break
+		}
+
+		first := remainderSplit(len(loader.levels[level]), tree.Order)
+		loader.cutIndexParent(level, first)
+		if len(loader.levels[level]) > 0 {
+			loader.cutIndexParent(level, len(loader.levels[level]))
+		}
+	}
+
+	saveMetadata(tree, loader.file)
+	return tree
+}
@@ -0,0 +1,101 @@
+package btree
+
+import "os"
+
+// CompactFile is the name of the file a tree is compacted into before it replaces the index file.
+func CompactFile(indexName string) string {
+	return indexName + ".compact"
+}
+
+// Compact rewrites the live entries of the tree densely into a fresh file and atomically renames it over the index
+// file. Handles still open on the old file keep reading the old pages until they are closed. The returned tree
+// describes the compacted file and replaces the receiver.
+func (tree *BTree[TKey, TValue]) Compact(file *os.File) (*BTree[TKey, TValue], error) {
+	compacted, err := tree.WriteCompacted(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = compacted.CommitCompacted(); err != nil {
+		return nil, err
+	}
+	return compacted, nil
+}
+
+// WriteCompacted writes the live entries of the tree into CompactFile without swapping it in. When mapValue is
+// given every value is passed through it before being written, an error from mapValue stops the compaction. The
+// compacted tree keeps the IndexName and the options of the receiver.
+func (tree *BTree[TKey, TValue]) WriteCompacted(file *os.File, mapValue func(TKey, TValue) (TValue, error)) (*BTree[TKey, TValue], error) {
+	compactFile, err := os.OpenFile(CompactFile(tree.IndexName), os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer compactFile.Close()
+
+	e, err := tree.SeekFirst(file)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Close()
+
+	entries := &compactionEntries[TKey, TValue]{enumerator: e, file: file, mapValue: mapValue}
+	compacted, err := BulkLoad[TKey, TValue](tree.IndexName, tree.Options(), tree.codec, compactFile, entries, 1)
+	if err != nil {
+		return nil, err
+	}
+	compacted.pool = tree.pool
+	return compacted, nil
+}
+
+// CommitCompacted atomically replaces the index file with the file written by WriteCompacted. The write-ahead log
+// and the page pool describe the old file, so it is checkpointed before the swap and its pages are discarded
+// after it.
+func (tree *BTree[TKey, TValue]) CommitCompacted() error {
+	file, err := os.OpenFile(tree.IndexName, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = Checkpoint(file, tree.pool)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(CompactFile(tree.IndexName), tree.IndexName); err != nil {
+		return err
+	}
+	tree.pool.Discard(tree.IndexName)
+	return nil
+}
+
+// DiscardCompacted removes the file written by WriteCompacted without swapping it in.
+func (tree *BTree[TKey, TValue]) DiscardCompacted() error {
+	if err := os.Remove(CompactFile(tree.IndexName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// compactionEntries yields the entries of a tree for its compaction, passed through mapValue when it is given.
+type compactionEntries[TKey, TValue any] struct {
+	enumerator *Enumerator[TKey, TValue]
+	file       *os.File
+	mapValue   func(TKey, TValue) (TValue, error)
+}
+
+func (entries *compactionEntries[TKey, TValue]) HasNext() bool {
+	return entries.enumerator.HasNext()
+}
+
+func (entries *compactionEntries[TKey, TValue]) Next() (TKey, TValue, error) {
+	key, value, err := entries.enumerator.Next(entries.file)
+	if err != nil {
+		var zeroKey TKey
+		var zeroValue TValue
+		return zeroKey, zeroValue, err
+	}
+	if entries.mapValue == nil {
+		return *key, *value, nil
+	}
+	mapped, err := entries.mapValue(*key, *value)
+	return *key, mapped, err
+}
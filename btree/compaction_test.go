@@ -0,0 +1,107 @@
+package btree
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestFile creates an empty index file closed when the test ends.
+func openTestFile(t *testing.T) *os.File {
+	t.Helper()
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), "test.idx"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+// keysOf returns the keys of the tree in order along with their values.
+func keysOf(t *testing.T, tree *BTree[int, int], file *os.File) map[int]int {
+	t.Helper()
+	e, err := tree.SeekFirst(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	entries := map[int]int{}
+	previous := -1
+	for e.HasNext() {
+		key, value, err := e.Next(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *key <= previous {
+			t.Fatalf("key %d after %d", *key, previous)
+		}
+		previous = *key
+		entries[*key] = *value
+	}
+	return entries
+}
+
+func TestCompactKeepsEntries(t *testing.T) {
+	for _, order := range []int{4, 7} {
+		file := openTestFile(t)
+		tree, err := NewTree[int, int](file.Name(), Options{Order: order}, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := rand.New(rand.NewSource(int64(order)))
+		for _, key := range r.Perm(2000) {
+			if err = tree.Put(key, key*10, file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, key := range r.Perm(2000)[:1500] {
+			if _, err = tree.Delete(key, file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := keysOf(t, tree, file)
+		before, err := file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compacted, err := tree.Compact(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := os.OpenFile(file.Name(), os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		after, err := reopened.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Size() >= before.Size() {
+			t.Errorf("order %d: compacted file has %d bytes, %d before", order, after.Size(), before.Size())
+		}
+
+		got := keysOf(t, compacted, reopened)
+		if len(got) != len(want) || compacted.Count != len(want) {
+			t.Fatalf("order %d: %d keys after compaction, want %d", order, len(got), len(want))
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Fatalf("order %d: key %d holds %d, want %d", order, key, got[key], value)
+			}
+		}
+
+		// The compacted tree takes writes again
+		for key := 2000; key < 2100; key++ {
+			if err = compacted.Put(key, key, reopened); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := keysOf(t, compacted, reopened); len(got) != len(want)+100 {
+			t.Fatalf("order %d: %d keys after writes, want %d", order, len(got), len(want)+100)
+		}
+	}
+}
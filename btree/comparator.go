@@ -0,0 +1,178 @@
+package btree
+
+import (
+	"bptree/utils"
+	"cmp"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Comparator orders the keys of a tree, returning a negative number when a sorts before b, zero when they are the
+// same key and a positive number when a sorts after b. The comparator is chosen when a tree is created and its
+// name is recorded in the metadata, a tree recording a comparator that is not registered cannot be opened.
+type Comparator func(a, b any) int
+
+// Names of the comparators registered by the package
+const (
+	// BinaryComparator orders keys with utils.Compare, strings by their bytes.
+	BinaryComparator = "binary"
+
+	// CaseFoldComparator orders strings ignoring case, strings differing only in case are the same key. Other keys
+	// are ordered like BinaryComparator.
+	CaseFoldComparator = "casefold"
+
+	// NaturalComparator orders the runs of digits within strings by their numeric value, e.g. "file2" before
+	// "file10". Other keys are ordered like BinaryComparator.
+	NaturalComparator = "natural"
+)
+
+var (
+	comparatorsLock sync.RWMutex
+	comparators     = map[string]Comparator{
+		BinaryComparator:   utils.Compare,
+		CaseFoldComparator: compareCaseFolded,
+		NaturalComparator:  compareNatural,
+	}
+)
+
+// RegisterComparator makes comparator available to the trees recording name. Like gob.Register it is meant to be
+// called from an init function and panics when another comparator is registered under the same name.
+func RegisterComparator(name string, comparator Comparator) {
+	comparatorsLock.Lock()
+	defer comparatorsLock.Unlock()
+
+	if _, ok := comparators[name]; ok {
+		panic(fmt.Sprintf("btree: comparator %q registered twice", name))
+	}
+	comparators[name] = comparator
+}
+
+// LookupComparator returns the comparator registered under name, the empty name standing for BinaryComparator.
+func LookupComparator(name string) (Comparator, bool) {
+	if name == "" {
+		name = BinaryComparator
+	}
+
+	comparatorsLock.RLock()
+	defer comparatorsLock.RUnlock()
+
+	comparator, ok := comparators[name]
+	return comparator, ok
+}
+
+// Compare orders two keys with the comparator of the tree. Composite keys and the keys of a tree with descending
+// components are compared component by component, a key that is not composite standing for its only component.
+func (tree *BTree[TKey, TValue]) Compare(a, b TKey) int {
+	_, isComposite := any(a).(CompositeKey)
+	_, bothComposite := any(b).(CompositeKey)
+	if isComposite || bothComposite || len(tree.Descending) > 0 {
+		return compareComposite(components(a), components(b), tree.compare(), tree.Descending)
+	}
+	return tree.compare()(a, b)
+}
+
+// compare returns the comparator ordering single components.
+func (tree *BTree[TKey, TValue]) compare() Comparator {
+	if tree.comparator == nil {
+		return utils.Compare
+	}
+	return tree.comparator
+}
+
+// useComparator resolves the comparator recorded in metadata read from an index file.
+func (tree *BTree[TKey, TValue]) useComparator() error {
+	comparator, ok := LookupComparator(tree.Comparator)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownComparator, tree.Comparator)
+	}
+	tree.comparator = comparator
+	return nil
+}
+
+// stringKey returns the string held by key, which may be of a named string type.
+func stringKey(key any) (string, bool) {
+	if text, ok := key.(string); ok {
+		return text, true
+	}
+	value := reflect.ValueOf(key)
+	if value.Kind() == reflect.String {
+		return value.String(), true
+	}
+	return "", false
+}
+
+func compareCaseFolded(a, b any) int {
+	x, isString := stringKey(a)
+	y, bothStrings := stringKey(b)
+	if !isString || !bothStrings {
+		return utils.Compare(a, b)
+	}
+
+	for x != "" && y != "" {
+		r, xLength := utf8.DecodeRuneInString(x)
+		s, yLength := utf8.DecodeRuneInString(y)
+		if result := cmp.Compare(foldRune(r), foldRune(s)); result != 0 {
+			return result
+		}
+		x, y = x[xLength:], y[yLength:]
+	}
+	return cmp.Compare(len(x), len(y))
+}
+
+// foldRune returns the smallest rune equal to r under simple case folding, the same for every case of a letter.
+func foldRune(r rune) rune {
+	folded := r
+	for other := unicode.SimpleFold(r); other != r; other = unicode.SimpleFold(other) {
+		folded = min(folded, other)
+	}
+	return folded
+}
+
+func compareNatural(a, b any) int {
+	x, isString := stringKey(a)
+	y, bothStrings := stringKey(b)
+	if !isString || !bothStrings {
+		return utils.Compare(a, b)
+	}
+
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		if !isDigit(x[i]) || !isDigit(y[j]) {
+			if result := cmp.Compare(x[i], y[j]); result != 0 {
+				return result
+			}
+			i, j = i+1, j+1
+			continue
+		}
+
+		xStart, yStart := i, j
+		for i < len(x) && isDigit(x[i]) {
+			i++
+		}
+		for j < len(y) && isDigit(y[j]) {
+			j++
+		}
+		// Numbers of the same value compare equal whatever their leading zeros
+		xNumber := strings.TrimLeft(x[xStart:i], "0")
+		yNumber := strings.TrimLeft(y[yStart:j], "0")
+		if result := cmp.Compare(len(xNumber), len(yNumber)); result != 0 {
+			return result
+		}
+		if result := strings.Compare(xNumber, yNumber); result != 0 {
+			return result
+		}
+	}
+	if result := cmp.Compare(len(x)-i, len(y)-j); result != 0 {
+		return result
+	}
+	// Strings differing only in leading zeros are still different keys
+	return strings.Compare(x, y)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
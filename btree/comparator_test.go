@@ -0,0 +1,102 @@
+package btree
+
+import (
+	"bptree/utils"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+// reverseComparator is registered for the tests, ordering keys from the largest.
+const reverseComparator = "test-reverse"
+
+func init() {
+	RegisterComparator(reverseComparator, func(a, b any) int { return -utils.Compare(a, b) })
+}
+
+func TestRegisteredComparatorsOrderStrings(t *testing.T) {
+	for _, c := range []struct {
+		comparator string
+		a, b       any
+		want       int
+	}{
+		{BinaryComparator, "B", "a", -1},
+		{CaseFoldComparator, "B", "a", 1},
+		{CaseFoldComparator, "Straße", "STRASSE", 1},
+		{CaseFoldComparator, "ΣΊΣΥΦΟΣ", "σίσυφος", 0},
+		{CaseFoldComparator, 2, "a", -1},
+		{NaturalComparator, "file2", "file10", -1},
+		{NaturalComparator, "file02", "file2", -1},
+		{NaturalComparator, "v1.10", "v1.9", 1},
+		{NaturalComparator, "a", "a1", -1},
+		{NaturalComparator, 10, 9, 1},
+	} {
+		comparator, ok := LookupComparator(c.comparator)
+		if !ok {
+			t.Fatal(c.comparator, "not registered")
+		}
+		if got := comparator(c.a, c.b); max(-1, min(got, 1)) != c.want {
+			t.Errorf("%s comparator orders %v against %v as %d, want %d", c.comparator, c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestTreeRecordsItsComparator(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4, Comparator: reverseComparator}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 20; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, file = reopen(t, file)
+	var keys []int
+	e, err := tree.SeekFirst(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for e.HasNext() {
+		key, _, err := e.Next(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, *key)
+	}
+	e.Close()
+	if !slices.IsSortedFunc(keys, func(a, b int) int { return b - a }) || len(keys) != 20 {
+		t.Fatal("reopened tree walked", keys)
+	}
+
+	for _, options := range []Options{{Comparator: BinaryComparator}, {Comparator: NaturalComparator}} {
+		if err = tree.CheckOptions(options); !errors.Is(err, ErrOptionsMismatch) {
+			t.Errorf("comparator %q checked against %q: %v", options.Comparator, reverseComparator, err)
+		}
+	}
+	if err = tree.CheckOptions(Options{Comparator: reverseComparator}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tree recording a comparator that is not registered, e.g. by another program, is not opened
+	comparatorsLock.Lock()
+	comparator := comparators[reverseComparator]
+	delete(comparators, reverseComparator)
+	comparatorsLock.Unlock()
+	defer func() {
+		comparatorsLock.Lock()
+		comparators[reverseComparator] = comparator
+		comparatorsLock.Unlock()
+	}()
+	file, err = os.Open(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = ReadMetadata[int, int](file); !errors.Is(err, ErrUnknownComparator) {
+		t.Fatal("tree of an unregistered comparator opened:", err)
+	}
+}
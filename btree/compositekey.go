@@ -0,0 +1,67 @@
+package btree
+
+import (
+	"cmp"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompositeKey is the key of an index over several fields, holding the value of every field in the order of the
+// fields. Composite keys are ordered component by component with the comparator of the tree, each component in the
+// direction recorded in Options.Descending. A key that is a prefix of another sorts before it, so seeking a prefix
+// lands on the first key starting with it.
+type CompositeKey []any
+
+func init() {
+	gob.Register(CompositeKey{})
+}
+
+// String renders the components of the key, quoting strings so that the names of sub index files built from it
+// tell keys apart.
+func (key CompositeKey) String() string {
+	components := make([]string, len(key))
+	for i, component := range key {
+		if text, ok := stringKey(component); ok {
+			components[i] = strconv.Quote(text)
+		} else {
+			components[i] = fmt.Sprint(component)
+		}
+	}
+	return "(" + strings.Join(components, ",") + ")"
+}
+
+// components returns the components of key, a key that is not composite being its only component.
+func components(key any) []any {
+	if composite, ok := key.(CompositeKey); ok {
+		return composite
+	}
+	return []any{key}
+}
+
+// compareComposite orders x and y component by component, negating the order of the descending components.
+func compareComposite(x, y []any, compare Comparator, descending []bool) int {
+	for i := range min(len(x), len(y)) {
+		result := compare(x[i], y[i])
+		if i < len(descending) && descending[i] {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return cmp.Compare(len(x), len(y))
+}
+
+// ComparePrefix orders key against prefix like Compare, ignoring the components of a composite key beyond the
+// length of prefix. Every key starting with prefix compares equal to it, e.g. when checking the upper bound of a
+// range over the leading fields of a composite index.
+func (tree *BTree[TKey, TValue]) ComparePrefix(key, prefix TKey) int {
+	composite, isComposite := any(key).(CompositeKey)
+	prefixComposite, prefixIsComposite := any(prefix).(CompositeKey)
+	if isComposite && prefixIsComposite && len(composite) > len(prefixComposite) {
+		return compareComposite(composite[:len(prefixComposite)], prefixComposite, tree.compare(), tree.Descending)
+	}
+	return tree.Compare(key, prefix)
+}
@@ -2,11 +2,6 @@ package btree
 
 import "os"
 
-type BleedDataPage[TKey, TValue any] struct {
-	Container []*DataNode[TKey, TValue]
-	BleedPage int
-}
-
 type DataNode[TKey, TValue any] struct {
 	Key    TKey
 	Value  TValue
@@ -20,7 +15,7 @@ type DataPage[TKey, TValue any] struct {
 	Next, Previous int
 	Parent         int
 	Offset         int
-	bleedPage      int
+	bleedPage      int // Head of the bleed blocks holding the encoding beyond the block, 0 when it fits
 }
 
 func newDataNode[TKey, TValue any](key TKey, value TValue) DataNode[TKey, TValue] {
@@ -40,12 +35,10 @@ func newDataPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File) *Da
 		Parent:    -1,
 		Next:      -1,
 		Previous:  -1,
-		bleedPage: -1,
 	}
 
-	SaveDataPage[TKey, TValue](tree, page, file, tree.LatestOffset)
-	tree.LatestOffset += PageBlockSize
-	SaveMetadata(tree, file)
+	saveDataPage[TKey, TValue](tree, page, file, tree.allocatePage(tree.PageBlockSize, file))
+	saveMetadata(tree, file)
 	return page
 }
 
@@ -66,7 +59,7 @@ func (dp *DataPage[TKey, TValue]) isMergeable() bool {
 }
 
 func (dp *DataPage[TKey, TValue]) find(key TKey) (*DataNode[TKey, TValue], bool) {
-	index, found := binarySearchPage[TKey, TValue](dp.Container, key)
+	index, found := binarySearchPage[TKey, TValue](dp.Container, key, dp.tree.Compare)
 	if found {
 		return &dp.Container[index], true
 	}
@@ -74,10 +67,10 @@ func (dp *DataPage[TKey, TValue]) find(key TKey) (*DataNode[TKey, TValue], bool)
 }
 
 func (dp *DataPage[TKey, TValue]) findAndUpdateIfExists(key TKey, file *os.File, value TValue) (*DataNode[TKey, TValue], int, bool /*isFound*/) {
-	index, found := binarySearchPage[TKey, TValue](dp.Container, key)
+	index, found := binarySearchPage[TKey, TValue](dp.Container, key, dp.tree.Compare)
 	if found {
 		dp.Container[index].Value = value
-		SaveDataPage[TKey, TValue](dp.tree, dp, file, dp.Offset)
+		saveDataPage[TKey, TValue](dp.tree, dp, file, dp.Offset)
 		return &dp.Container[index], index, true
 	}
 	return nil, index, false
@@ -94,6 +87,7 @@ func (dp *DataPage[TKey, TValue]) insertAt(index int, key TKey, value TValue) {
 
 func (dp *DataPage[TKey, TValue]) deleteAtIndexAndSort(index int) {
 	copy(dp.Container[index:], dp.Container[index+1:])
+	dp.Container[len(dp.Container)-1] = DataNode[TKey, TValue]{}
 	dp.Count--
 }
 
@@ -102,12 +96,43 @@ func (dp *DataPage[TKey, TValue]) deleteAt(index int) {
 	dp.Count--
 }
 
-func (dp *DataPage[TKey, TValue]) split(file *os.File) *DataPage[TKey, TValue] {
+// bleeds reports whether the page outgrew its block when it was last saved and still holds enough entries to split.
+func (dp *DataPage[TKey, TValue]) bleeds() bool {
+	return dp.bleedPage != 0 && dp.Count >= 2
+}
+
+// splitPoint returns the first slot moved to the new page when the page is split. A page full by count is split in
+// the middle, a page that bleeds where the encoded entries on either side are closest in size.
+func (dp *DataPage[TKey, TValue]) splitPoint() int {
+	if !dp.bleeds() {
+		return dp.tree.MidPoint
+	}
+
+	sizes := make([]int, dp.Count)
+	total := 0
+	for i := range sizes {
+		sizes[i] = encodedLength(dp.tree, &DataPage[TKey, TValue]{Count: 1, Container: dp.Container[i : i+1]})
+		total += sizes[i]
+	}
+
+	at, left, best := 1, sizes[0], total
+	for i := 1; i < dp.Count; i++ {
+		if larger := max(left, total-left); larger < best {
+			at, best = i, larger
+		}
+		left += sizes[i]
+	}
+	return at
+}
+
+// split moves the entries from slot at on into a new page.
+func (dp *DataPage[TKey, TValue]) split(at int, file *os.File) *DataPage[TKey, TValue] {
 	splitDict := newDataPage[TKey, TValue](dp.tree, file)
 
 	// Create a new data page and copy second half data
-	splitDict.Count = copy(splitDict.Container[0:], dp.Container[dp.tree.MidPoint:])
-	for i := dp.tree.MidPoint; i < dp.tree.Order; i++ {
+	count := dp.Count
+	splitDict.Count = copy(splitDict.Container[0:], dp.Container[at:count])
+	for i := at; i < count; i++ {
 		dp.deleteAt(i)
 	}
 	return splitDict
@@ -9,42 +9,66 @@ type Enumerator[TKey, V any] struct {
 	tree             *BTree[TKey, V]
 }
 
-func (enumerator *Enumerator[TKey, V]) Next(file *os.File) (*TKey, *V) {
+func (enumerator *Enumerator[TKey, V]) Next(file *os.File) (*TKey, *V, error) {
 	if !enumerator.HasNext() {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	if enumerator.i < enumerator.dataPage.Count-1 {
 		enumerator.i++
 		key := &enumerator.dataPage.Container[enumerator.i].Key
 		value := &enumerator.dataPage.Container[enumerator.i].Value
-		return key, value
+		return key, value, nil
 	} else {
-		enumerator.dataPage = ReadDataPage(enumerator.tree, file, enumerator.dataPage.Next)
+		nextPage, err := ReadDataPage(enumerator.tree, file, enumerator.dataPage.Next)
+		if err != nil {
+			return nil, nil, err
+		}
+		enumerator.dataPage = nextPage
 		enumerator.i = 0
 		key := &enumerator.dataPage.Container[enumerator.i].Key
 		value := &enumerator.dataPage.Container[enumerator.i].Value
-		return key, value
+		return key, value, nil
 	}
 }
 
-func (enumerator *Enumerator[TKey, V]) Previous(file *os.File) (*TKey, *V) {
-	if !enumerator.HasPrevious() {
+// Peek returns the key Next returns next without moving the enumerator.
+func (enumerator *Enumerator[TKey, V]) Peek(file *os.File) (*TKey, error) {
+	if !enumerator.HasNext() {
 		return nil, nil
 	}
 
+	if enumerator.i < enumerator.dataPage.Count-1 {
+		return &enumerator.dataPage.Container[enumerator.i+1].Key, nil
+	}
+	nextPage, err := ReadDataPage(enumerator.tree, file, enumerator.dataPage.Next)
+	if err != nil {
+		return nil, err
+	}
+	return &nextPage.Container[0].Key, nil
+}
+
+func (enumerator *Enumerator[TKey, V]) Previous(file *os.File) (*TKey, *V, error) {
+	if !enumerator.HasPrevious() {
+		return nil, nil, nil
+	}
+
 	if enumerator.i >= 0 {
 		key := &enumerator.dataPage.Container[enumerator.i].Key
 		value := &enumerator.dataPage.Container[enumerator.i].Value
 		enumerator.i--
-		return key, value
+		return key, value, nil
 	} else {
-		enumerator.dataPage = ReadDataPage(enumerator.tree, file, enumerator.dataPage.Previous)
+		previousPage, err := ReadDataPage(enumerator.tree, file, enumerator.dataPage.Previous)
+		if err != nil {
+			return nil, nil, err
+		}
+		enumerator.dataPage = previousPage
 		enumerator.i = enumerator.dataPage.Count - 1
 		key := &enumerator.dataPage.Container[enumerator.i].Key
 		value := &enumerator.dataPage.Container[enumerator.i].Value
 		enumerator.i--
-		return key, value
+		return key, value, nil
 	}
 }
 
@@ -53,7 +77,7 @@ func (enumerator *Enumerator[TKey, V]) HasNext() bool {
 }
 
 func (enumerator *Enumerator[TKey, V]) HasPrevious() bool {
-	return enumerator.i > 0 || enumerator.dataPage.Previous != -1
+	return enumerator.i >= 0 || enumerator.dataPage.Previous != -1
 }
 
 func (enumerator *Enumerator[TKey, V]) Close() {
@@ -0,0 +1,74 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	DataPageKind  = "data"
+	IndexPageKind = "index"
+	MetadataKind  = "metadata"
+	FreePageKind  = "free"
+)
+
+var (
+	// ErrCorrupt matches every ErrCorruptPage with errors.Is.
+	ErrCorrupt = errors.New("corrupt index")
+
+	// ErrUnsorted is returned by a bulk load given keys that are not unique and in ascending order.
+	ErrUnsorted = errors.New("bulk load keys must be unique and in ascending order")
+
+	// ErrUnknownCodec is returned when an index file records a page codec that is not registered.
+	ErrUnknownCodec = errors.New("unknown page codec")
+
+	// ErrUnknownComparator is returned when a tree is created with or an index file records a comparator that is
+	// not registered.
+	ErrUnknownComparator = errors.New("unknown comparator")
+
+	// ErrInvalidOptions is returned when a tree is created with options it cannot be laid out with.
+	ErrInvalidOptions = errors.New("invalid tree options")
+
+	// ErrOptionsMismatch is returned by CheckOptions when a tree was created with other options.
+	ErrOptionsMismatch = errors.New("tree was created with other options")
+
+	// ErrSnapshotReleased is returned when a tree viewed at a snapshot is read after the snapshot was released.
+	ErrSnapshotReleased = errors.New("snapshot was released")
+)
+
+// ErrCorruptPage is returned when a block of an index file fails its checksum or cannot be decoded, e.g. after a
+// torn write or silent corruption on disk.
+type ErrCorruptPage struct {
+	Offset int
+	Kind   string
+	Reason string
+}
+
+func (err ErrCorruptPage) Error() string {
+	return fmt.Sprintf("corrupt %s page at offset %d: %s", err.Kind, err.Offset, err.Reason)
+}
+
+func (err ErrCorruptPage) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// treeError carries a failure raised while pages are read or written deep inside a split, merge or walk up to the
+// exported method that started it, so the restructuring code does not have to return an error from every step.
+type treeError struct {
+	err error
+}
+
+func throw(err error) {
+	panic(treeError{err: err})
+}
+
+// catch stops a failure raised by throw and stores it in err. Any other panic is a bug and keeps unwinding.
+func catch(err *error) {
+	if r := recover(); r != nil {
+		failure, ok := r.(treeError)
+		if !ok {
+			panic(r)
+		}
+		*err = failure.err
+	}
+}
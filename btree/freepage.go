@@ -0,0 +1,54 @@
+package btree
+
+import "os"
+
+// FreePage is written over a block once it is released. Free blocks of the same size are chained through Next
+// into a list whose head is kept in the tree metadata. Offset 0 always holds the metadata, so it marks the end of
+// a list.
+type FreePage struct {
+	Next int
+}
+
+// freeListHead returns the free list of blocks of blockSize, index and data blocks of the same size share a list.
+func (tree *BTree[TKey, TValue]) freeListHead(blockSize int) *int {
+	if blockSize == tree.IndexBlockSize {
+		return &tree.FreeIndexPage
+	}
+	return &tree.FreeDataPage
+}
+
+// allocatePage returns an offset for a new block, reusing a released block before extending the file.
+func (tree *BTree[TKey, TValue]) allocatePage(blockSize int, file *os.File) int {
+	head := tree.freeListHead(blockSize)
+	if *head != 0 {
+		offset := *head
+		var freePage FreePage
+		if _, err := readPage(tree, &freePage, file, offset, blockSize); err != nil {
+			throw(err)
+		}
+		*head = freePage.Next
+		return offset
+	}
+
+	offset := tree.LatestOffset
+	tree.LatestOffset += blockSize
+	return offset
+}
+
+// freePage releases the block at offset so that a later allocation of the same size can reuse it.
+func (tree *BTree[TKey, TValue]) freePage(offset int, blockSize int, file *os.File) {
+	head := tree.freeListHead(blockSize)
+	saveAt(tree, &FreePage{Next: *head}, file, offset, blockSize)
+	*head = offset
+	saveMetadata(tree, file)
+}
+
+func (tree *BTree[TKey, TValue]) freeDataPage(page *DataPage[TKey, TValue], file *os.File) {
+	tree.freeBleedBlocks(page.bleedPage, tree.PageBlockSize, file)
+	tree.freePage(page.Offset, tree.PageBlockSize, file)
+}
+
+func (tree *BTree[TKey, TValue]) freeIndexPage(page *IndexPage[TKey, TValue], file *os.File) {
+	tree.freeBleedBlocks(page.bleedPage, tree.IndexBlockSize, file)
+	tree.freePage(page.Offset, tree.IndexBlockSize, file)
+}
@@ -0,0 +1,45 @@
+package btree
+
+import "testing"
+
+func TestFreedPagesAreReused(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 500; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key := 50; key < 500; key++ {
+		if _, err = tree.Delete(key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	freeData, freeIndex := freeBlocks(t, tree, file, tree.PageBlockSize), freeBlocks(t, tree, file, tree.IndexBlockSize)
+	if freeData == 0 || freeIndex == 0 {
+		t.Fatalf("%d data and %d index pages freed by merges", freeData, freeIndex)
+	}
+
+	// The free lists are kept in the metadata
+	tree, file = reopen(t, file)
+	if got := freeBlocks(t, tree, file, tree.PageBlockSize); got != freeData {
+		t.Fatalf("%d free data pages after reopening, want %d", got, freeData)
+	}
+	fileEnd := tree.LatestOffset
+	for key := 50; key < 500; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.LatestOffset != fileEnd {
+		t.Fatalf("file grew from %d to %d with %d data and %d index pages free, %d and %d left",
+			fileEnd, tree.LatestOffset, freeData, freeIndex,
+			freeBlocks(t, tree, file, tree.PageBlockSize), freeBlocks(t, tree, file, tree.IndexBlockSize))
+	}
+	if entries := keysOf(t, tree, file); len(entries) != 500 {
+		t.Fatal(len(entries), "entries")
+	}
+}
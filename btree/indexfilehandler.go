@@ -2,29 +2,51 @@ package btree
 
 import (
 	"bytes"
-	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 )
 
+// Block sizes of trees created without choosing them, see Options.
 const (
-	MetadataSize   = 1 * 1024
-	IndexBlockSize = 4 * 1024
-	PageBlockSize  = 16 * 1024
+	DefaultMetadataSize   = 1 * 1024
+	DefaultIndexBlockSize = 4 * 1024
+	DefaultPageBlockSize  = 16 * 1024
 )
 
-var BUFFER_POOL map[int]*sync.Pool = createBufferPool()
+const checksumLength = 8 // Hex digits of a crc32 checksum
 
-func createBufferPool() map[int]*sync.Pool {
-	pool := map[int]*sync.Pool{}
+// maxFrameLength bounds the "<length>@<bleedPage>:<crc32c>:" prefix formatBytesToWrite puts in front of a page.
+const maxFrameLength = 20 + 1 + 20 + 1 + checksumLength + 1
 
-	pool[MetadataSize] = &sync.Pool{New: func() any { return (make([]byte, MetadataSize)) }}
-	pool[IndexBlockSize] = &sync.Pool{New: func() any { return make([]byte, IndexBlockSize) }}
-	pool[PageBlockSize] = &sync.Pool{New: func() any { return make([]byte, PageBlockSize) }}
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
 
-	return pool
+var BUFFER_POOL = &bufferPool{}
+
+// bufferPool recycles the buffers blocks are read into, keeping a sync.Pool for every block size in use.
+type bufferPool struct {
+	pools sync.Map // Block size to *sync.Pool
+}
+
+func (pool *bufferPool) Get(length int) []byte {
+	return pool.sizePool(length).Get().([]byte)
+}
+
+func (pool *bufferPool) Put(buffer []byte) {
+	pool.sizePool(len(buffer)).Put(buffer)
+}
+
+func (pool *bufferPool) sizePool(length int) *sync.Pool {
+	if sizePool, ok := pool.pools.Load(length); ok {
+		return sizePool.(*sync.Pool)
+	}
+	sizePool, _ := pool.pools.LoadOrStore(length, &sync.Pool{New: func() any { return make([]byte, length) }})
+	return sizePool.(*sync.Pool)
 }
 
 type PageBlockType struct {
@@ -32,94 +54,316 @@ type PageBlockType struct {
 }
 
 type PageBlock[TKey, TValue any] interface {
-	*DataPage[TKey, TValue] | *IndexPage[TKey, TValue] | *BTree[TKey, TValue]
+	*DataPage[TKey, TValue] | *IndexPage[TKey, TValue] | *BTree[TKey, TValue] | *FreePage
 }
 
-func SaveAt[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](tree *BTree[TKey, TValue], page TPageBlock, file *os.File, offset int, length int) {
+// SaveAt encodes page into the block at offset. A data or index page that does not fit its block bleeds into a
+// chain of bleed blocks, the chain of the previous version of the page is released.
+func SaveAt[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](tree *BTree[TKey, TValue], page TPageBlock, file *os.File, offset int, length int) (err error) {
+	defer catch(&err)
 
-	binBytes := new(bytes.Buffer)
-	enc := gob.NewEncoder(binBytes)
-	err := enc.Encode(page)
+	dataBytes, err := encodePage[TKey, TValue](tree.codec, page)
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	var writeBytes []byte = formatBytesToWrite(binBytes, length)
+	tree.freeBleedBlocks(bleedPageOf[TKey, TValue](page), length, file)
+	bleedPage := 0
+	if len(dataBytes) > length-maxFrameLength {
+		kind := pageKind[TKey, TValue](page)
+		if kind != DataPageKind && kind != IndexPageKind {
+			return fmt.Errorf("encoded %s page of %d bytes exceeds its %d byte block", kind, len(dataBytes), length)
+		}
+		bleedPage = tree.writeBleedBlocks(dataBytes[length-maxFrameLength:], length, file)
+	}
+	setBleedPage[TKey, TValue](page, bleedPage)
 
-	if _, err = file.WriteAt(writeBytes, int64(offset)); err != nil {
-		panic(err)
+	var writeBytes []byte = formatBytesToWrite(dataBytes, bleedPage, length)
+
+	if tree.batch != nil {
+		// Inside a mutation the page is logged on commit before it reaches the index file
+		tree.batch.put(offset, writeBytes, clonePage[TKey, TValue](page))
+		return nil
 	}
+
+	_, err = file.WriteAt(writeBytes, int64(offset))
+	return err
 }
 
-func SaveDataPage[TKey, TValue any](tree *BTree[TKey, TValue], page *DataPage[TKey, TValue], file *os.File, offset int) {
+func SaveDataPage[TKey, TValue any](tree *BTree[TKey, TValue], page *DataPage[TKey, TValue], file *os.File, offset int) error {
 	page.Offset = offset
-	SaveAt(tree, page, file, offset, PageBlockSize)
+	return SaveAt(tree, page, file, offset, tree.PageBlockSize)
 }
 
-func SaveIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], page *IndexPage[TKey, TValue], file *os.File, offset int) {
+func SaveIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], page *IndexPage[TKey, TValue], file *os.File, offset int) error {
 	page.Offset = offset
-	SaveAt(tree, page, file, offset, IndexBlockSize)
+	return SaveAt(tree, page, file, offset, tree.IndexBlockSize)
 }
 
-func SaveMetadata[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File) {
-	SaveAt(tree, tree, file, 0, MetadataSize)
+func SaveMetadata[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File) error {
+	return SaveAt(tree, tree, file, 0, tree.MetadataSize)
 }
 
-func ReadAt[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock, file *os.File, offset int, length int) TPageBlock {
+// saveAt, saveDataPage, saveIndexPage and saveMetadata are used while a tree is being restructured and throw
+// instead of returning their error.
+func saveAt[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](tree *BTree[TKey, TValue], page TPageBlock, file *os.File, offset int, length int) {
+	if err := SaveAt(tree, page, file, offset, length); err != nil {
+		throw(err)
+	}
+}
 
-	var datatToUnmarshal []byte
+func saveDataPage[TKey, TValue any](tree *BTree[TKey, TValue], page *DataPage[TKey, TValue], file *os.File, offset int) {
+	if err := SaveDataPage(tree, page, file, offset); err != nil {
+		throw(err)
+	}
+}
 
-	var buffer []byte = BUFFER_POOL[length].Get().([]byte)
-	defer BUFFER_POOL[length].Put(buffer)
+func saveIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], page *IndexPage[TKey, TValue], file *os.File, offset int) {
+	if err := SaveIndexPage(tree, page, file, offset); err != nil {
+		throw(err)
+	}
+}
+
+func saveMetadata[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File) {
+	if err := SaveMetadata(tree, file); err != nil {
+		throw(err)
+	}
+}
+
+// ReadAt reads and decodes the block at offset of file, pages are decoded with codec and gob decoded when it is nil.
+func ReadAt[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](codec PageCodec, page TPageBlock, file *os.File, offset int, length int) (TPageBlock, error) {
+	return readAt[TKey, TValue](codec, page, file, offset, length, func(offset int) ([]byte, error) {
+		return readBlock(file, offset, length)
+	})
+}
+
+// readAt reads the block at offset like ReadAt, reading its bleed blocks with readBleed.
+func readAt[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](codec PageCodec, page TPageBlock, file *os.File, offset int, length int,
+	readBleed func(offset int) ([]byte, error)) (TPageBlock, error) {
+	var buffer []byte = BUFFER_POOL.Get(length)
+	defer BUFFER_POOL.Put(buffer)
 	_, err := file.ReadAt(buffer, int64(offset))
 
+	if errors.Is(err, io.EOF) {
+		return page, ErrCorruptPage{Offset: offset, Kind: pageKind[TKey, TValue](page), Reason: "block is truncated"}
+	}
 	if err != nil {
-		panic(err)
+		return page, err
 	}
 
-	lengthBytes := buffer[:bytes.IndexRune(buffer, ':')]
-	dataLength, _ := strconv.Atoi(string(lengthBytes))
-
-	datatToUnmarshal = buffer[len(lengthBytes)+1 : len(lengthBytes)+1+dataLength]
+	return decodeBlock[TKey, TValue](codec, page, buffer, offset, readBleed)
+}
 
-	dec := gob.NewDecoder(bytes.NewBuffer(datatToUnmarshal))
+// readPage reads a block of the tree, preferring the copy written by the mutation in progress and then the copy
+// in the page pool of the tree. A tree viewed at a snapshot reads the block as it was then.
+func readPage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](tree *BTree[TKey, TValue], page TPageBlock, file *os.File, offset int, length int) (TPageBlock, error) {
+	if tree.snapshot != nil {
+		return readSnapshotPage[TKey, TValue](tree, page, file, offset, length)
+	}
+	if buffer, ok := tree.batch.get(offset); ok {
+		return decodeBlock[TKey, TValue](tree.codec, page, buffer, offset, func(offset int) ([]byte, error) {
+			return tree.readBleedBlock(file, offset, length)
+		})
+	}
 
-	err = dec.Decode(page)
+	if pooled, ok := tree.pool.get(file, offset); ok {
+		if !restorePage[TKey, TValue](page, pooled) {
+			return page, ErrCorruptPage{Offset: offset, Kind: pageKind[TKey, TValue](page), Reason: "block holds another kind of page"}
+		}
+		return page, nil
+	}
 
+	page, err := ReadAt[TKey, TValue](tree.codec, page, file, offset, length)
 	if err != nil {
-		panic(err)
+		return page, err
 	}
+	return page, tree.pool.put(file, offset, clonePage[TKey, TValue](page))
+}
 
-	return page
+func pageKind[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock) string {
+	switch any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		return DataPageKind
+	case *IndexPage[TKey, TValue]:
+		return IndexPageKind
+	case *BTree[TKey, TValue]:
+		return MetadataKind
+	default:
+		return FreePageKind
+	}
 }
 
-func ReadDataPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, offset int) *DataPage[TKey, TValue] {
+// decodeBlock verifies the framing and checksum written by formatBytesToWrite and decodes the page in it, reading
+// the bleed blocks of a page that outgrew its block with readBleed. Blocks written before checksums were
+// introduced are framed as "<length>:<data>" and are decoded unverified.
+func decodeBlock[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](codec PageCodec, page TPageBlock, buffer []byte, offset int,
+	readBleed func(offset int) ([]byte, error)) (TPageBlock, error) {
+	corrupt := func(reason string) (TPageBlock, error) {
+		return page, ErrCorruptPage{Offset: offset, Kind: pageKind[TKey, TValue](page), Reason: reason}
+	}
+
+	lengthEnd := bytes.IndexByte(buffer, ':')
+	if lengthEnd <= 0 {
+		return corrupt("missing length prefix")
+	}
+	lengthPrefix, bleedPrefix, bleeds := strings.Cut(string(buffer[:lengthEnd]), "@")
+	dataLength, err := strconv.Atoi(lengthPrefix)
+	if err != nil || dataLength < 0 {
+		return corrupt("invalid length prefix")
+	}
+	bleedPage := 0
+	if bleeds {
+		if bleedPage, err = strconv.Atoi(bleedPrefix); err != nil || bleedPage <= 0 {
+			return corrupt("invalid bleed page")
+		}
+	}
+
+	dataStart := lengthEnd + 1
+	checksumEnd := dataStart + checksumLength
+	hasChecksum := checksumEnd < len(buffer) && buffer[checksumEnd] == ':'
+	var checksum uint64
+	if hasChecksum {
+		checksum, err = strconv.ParseUint(string(buffer[dataStart:checksumEnd]), 16, 32)
+		hasChecksum = err == nil
+	}
+	if hasChecksum {
+		dataStart = checksumEnd + 1
+	}
+
+	var datatToUnmarshal []byte
+	if bleeds && hasChecksum {
+		// Copied out of the block, which may be a pooled buffer
+		if dataStart > maxFrameLength {
+			return corrupt("frame exceeds its length")
+		}
+		datatToUnmarshal = append(make([]byte, 0, dataLength), buffer[dataStart:dataStart+len(buffer)-maxFrameLength]...)
+		if datatToUnmarshal, err = readBleedBlocks(datatToUnmarshal, bleedPage, dataLength, readBleed); err != nil {
+			return page, err
+		}
+		if len(datatToUnmarshal) != dataLength {
+			return corrupt("bleed chain does not match the length prefix")
+		}
+	} else {
+		if bleeds || dataStart+dataLength > len(buffer) {
+			return corrupt("length prefix exceeds the block")
+		}
+		datatToUnmarshal = buffer[dataStart : dataStart+dataLength]
+	}
+	if hasChecksum && crc32.Checksum(datatToUnmarshal, checksumTable) != uint32(checksum) {
+		return corrupt("checksum mismatch")
+	}
+
+	if err := decodePage[TKey, TValue](codec, page, datatToUnmarshal); err != nil {
+		return corrupt(err.Error())
+	}
+	setBleedPage[TKey, TValue](page, bleedPage)
+
+	return page, nil
+}
+
+func ReadDataPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, offset int) (*DataPage[TKey, TValue], error) {
 	var page DataPage[TKey, TValue]
-	page = *ReadAt[TKey, TValue](&page, file, offset, PageBlockSize)
+	if _, err := readPage(tree, &page, file, offset, tree.PageBlockSize); err != nil {
+		return nil, err
+	}
 	page.tree = tree
-	return &page
+	return &page, nil
 }
 
-func ReadIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, offset int) *IndexPage[TKey, TValue] {
+func ReadIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, offset int) (*IndexPage[TKey, TValue], error) {
 	var page IndexPage[TKey, TValue]
-	page = *ReadAt[TKey, TValue](&page, file, offset, IndexBlockSize)
+	if _, err := readPage(tree, &page, file, offset, tree.IndexBlockSize); err != nil {
+		return nil, err
+	}
 	page.tree = tree
-	return &page
+	return &page, nil
+}
+
+// readDataPage and readIndexPage are used while a tree is being walked and throw instead of returning their error.
+func readDataPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, offset int) *DataPage[TKey, TValue] {
+	page, err := ReadDataPage(tree, file, offset)
+	if err != nil {
+		throw(err)
+	}
+	return page
+}
+
+func readIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File, offset int) *IndexPage[TKey, TValue] {
+	page, err := ReadIndexPage(tree, file, offset)
+	if err != nil {
+		throw(err)
+	}
+	return page
+}
+
+// ReadMetadata reads the metadata of the tree in file. Its block is at least DefaultMetadataSize long, a larger
+// block is told by the length prefix of its frame.
+func ReadMetadata[TKey, TValue any](file *os.File) (*BTree[TKey, TValue], error) {
+	metadata, err := readMetadata[TKey, TValue](file)
+	if err != nil {
+		return nil, err
+	}
+	if err = metadata.useMetadata(); err != nil {
+		return nil, err
+	}
+	return metadata, nil
 }
 
-func ReadMetadata[TKey, TValue any](file *os.File) *BTree[TKey, TValue] {
+// readMetadata decodes the metadata block of file.
+func readMetadata[TKey, TValue any](file *os.File) (*BTree[TKey, TValue], error) {
+	length, err := metadataLength(file)
+	if err != nil {
+		return nil, err
+	}
+
 	var page BTree[TKey, TValue]
-	return ReadAt[TKey, TValue](&page, file, 0, MetadataSize)
+	return ReadAt[TKey, TValue](nil, &page, file, 0, length)
+}
+
+// useMetadata sets the tree up for the layout, the codec and the comparator its metadata records.
+func (tree *BTree[TKey, TValue]) useMetadata() error {
+	tree.useLayout()
+	if err := tree.useCodec(); err != nil {
+		return err
+	}
+	return tree.useComparator()
+}
+
+// metadataLength returns the number of bytes to read for the metadata block of file.
+func metadataLength(file *os.File) (int, error) {
+	prefix := make([]byte, maxFrameLength)
+	if _, err := file.ReadAt(prefix, 0); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	lengthEnd := bytes.IndexByte(prefix, ':')
+	if lengthEnd <= 0 {
+		return 0, ErrCorruptPage{Offset: 0, Kind: MetadataKind, Reason: "missing length prefix"}
+	}
+	dataLength, err := strconv.Atoi(string(prefix[:lengthEnd]))
+	if err != nil || dataLength < 0 {
+		return 0, ErrCorruptPage{Offset: 0, Kind: MetadataKind, Reason: "invalid length prefix"}
+	}
+	return max(DefaultMetadataSize, lengthEnd+1+checksumLength+1+dataLength), nil
 }
 
-func formatBytesToWrite(binBuffer *bytes.Buffer, length int) []byte {
+// formatBytesToWrite frames the encoded page as "<length>:<crc32c>:<data>" padded to the block length. A page
+// bleeding into the chain at bleedPage is framed as "<length>@<bleedPage>:<crc32c>:<data>" and only the first
+// length-maxFrameLength bytes of the data are kept in the block, the checksum still covers all of it.
+func formatBytesToWrite(dataBytes []byte, bleedPage int, length int) []byte {
 	var writeBytes []byte = make([]byte, length)
-	dataBytes := binBuffer.Bytes()
-	metaBytes := []byte(fmt.Sprintf("%s:", strconv.Itoa(len(dataBytes))))
+	lengthPrefix := strconv.Itoa(len(dataBytes))
+	if bleedPage != 0 {
+		lengthPrefix += "@" + strconv.Itoa(bleedPage)
+	}
+	metaBytes := []byte(fmt.Sprintf("%s:%0*x:", lengthPrefix, checksumLength, crc32.Checksum(dataBytes, checksumTable)))
 
 	copy(writeBytes[:len(metaBytes)], metaBytes)
-	copy(writeBytes[len(metaBytes):len(metaBytes)+len(dataBytes)], dataBytes)
+	if bleedPage != 0 {
+		dataBytes = dataBytes[:length-maxFrameLength]
+	}
+	copy(writeBytes[len(metaBytes):], dataBytes)
 
 	return writeBytes
 }
@@ -0,0 +1,79 @@
+package btree
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// flipPayloadByte flips a bit of the last byte of the page framed in the block at offset.
+func flipPayloadByte(t *testing.T, file *os.File, offset int) {
+	t.Helper()
+	prefix := make([]byte, maxFrameLength)
+	if _, err := file.ReadAt(prefix, int64(offset)); err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.SplitN(string(prefix), ":", 3)
+	length, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields) != 3 {
+		t.Fatalf("block at %d is not framed: %q", offset, prefix)
+	}
+	at := int64(offset + len(fields[0]) + len(fields[1]) + 2 + length - 1)
+	last := make([]byte, 1)
+	if _, err = file.ReadAt(last, at); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteAt([]byte{last[0] ^ 1}, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlippedByteIsReportedAsCorruptPage(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		keys int
+		kind string
+	}{
+		{"data page", 1, DataPageKind},
+		{"index page", 20, IndexPageKind},
+		{"metadata", 1, MetadataKind},
+	} {
+		file := openTestFile(t)
+		tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key := 0; key < c.keys; key++ {
+			if err = tree.Put(key, key, file); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		offset := tree.RootOffset
+		if c.kind == MetadataKind {
+			offset = 0
+		}
+		flipPayloadByte(t, file, offset)
+		// A new handle misses the pages pooled for the old one
+		file.Close()
+		if file, err = os.OpenFile(file.Name(), os.O_RDWR, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		if c.kind != MetadataKind {
+			_, _, err = tree.Get(0, file)
+		} else {
+			_, err = ReadMetadata[int, int](file)
+		}
+		var corruptPage ErrCorruptPage
+		if !errors.As(err, &corruptPage) || !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if corruptPage.Offset != offset || corruptPage.Kind != c.kind || corruptPage.Reason != "checksum mismatch" {
+			t.Fatalf("%s: %+v, want a %s page at %d", c.name, corruptPage, c.kind, offset)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package btree
 
 import (
-	"bptree/utils"
 	"os"
 )
 
@@ -19,6 +18,7 @@ type IndexPage[TKey, TValue any] struct {
 	IsChildrenDataPage bool
 	Parent             int
 	Offset             int
+	bleedPage          int // Head of the bleed blocks holding the encoding beyond the block, 0 when it fits
 }
 
 func (ip *IndexPage[TKey, TValue]) isDeficient() bool {
@@ -64,9 +64,8 @@ func newIndexPage[TKey, TValue any](tree *BTree[TKey, TValue], file *os.File) *I
 		newIndexPage.Children[i] = -1
 	}
 
-	SaveIndexPage[TKey, TValue](tree, newIndexPage, file, tree.LatestOffset)
-	tree.LatestOffset += IndexBlockSize
-	SaveMetadata(tree, file)
+	saveIndexPage[TKey, TValue](tree, newIndexPage, file, tree.allocatePage(tree.IndexBlockSize, file))
+	saveMetadata(tree, file)
 	return newIndexPage
 }
 
@@ -75,7 +74,7 @@ func (ip *IndexPage[TKey, TValue]) isOverflowing() bool {
 }
 
 func (ip *IndexPage[TKey, TValue]) insertSorted(key TKey) (int, bool) {
-	index, found := binarySearchPage[TKey, TValue](ip.Container, key)
+	index, found := binarySearchPage[TKey, TValue](ip.Container, key, ip.tree.Compare)
 
 	if !found {
 		// Key is not found
@@ -113,33 +112,78 @@ func (ip *IndexPage[TKey, TValue]) deleteChildAt(index int) {
 	ip.Children[index] = -1
 }
 
-func (ip *IndexPage[TKey, TValue]) split(file *os.File) *IndexPage[TKey, TValue] {
+// bleeds reports whether the page outgrew its block when it was last saved and still holds enough keys to split.
+func (ip *IndexPage[TKey, TValue]) bleeds() bool {
+	return ip.bleedPage != 0 && ip.Count >= 3
+}
+
+// splitPoint returns the slot of the key pushed up when the page is split. A page full by count is split in the
+// middle, a page that bleeds where the encoded keys left on either side are closest in size.
+func (ip *IndexPage[TKey, TValue]) splitPoint() int {
+	if !ip.bleeds() {
+		return ip.tree.MidPoint
+	}
+
+	sizes := make([]int, ip.Count)
+	total := 0
+	for i := range sizes {
+		sizes[i] = encodedLength(ip.tree, &IndexPage[TKey, TValue]{Count: 1, Container: ip.Container[i : i+1]})
+		total += sizes[i]
+	}
+
+	at, left, best := 1, sizes[0], total
+	for i := 1; i < ip.Count-1; i++ {
+		if larger := max(left, total-left-sizes[i]); larger < best {
+			at, best = i, larger
+		}
+		left += sizes[i]
+	}
+	return at
+}
+
+// split moves the keys after slot at into a new page, the key at slot at is dropped for the caller to push up.
+func (ip *IndexPage[TKey, TValue]) split(at int, file *os.File) *IndexPage[TKey, TValue] {
 	splitDict := newIndexPage[TKey, TValue](ip.tree, file)
 
 	// Create a new data page and copy second half data
-	splitDict.Count = copy(splitDict.Container[0:], ip.Container[ip.tree.MidPoint+1:])
+	count := ip.Count
+	splitDict.Count = copy(splitDict.Container[0:], ip.Container[at+1:count])
 	splitDict.IsChildrenDataPage = ip.IsChildrenDataPage
-	for i := ip.tree.MidPoint; i < ip.tree.Order; i++ {
+	for i := at; i < count; i++ {
 		ip.deleteAt(i)
 	}
 	return splitDict
 }
 
-func (ip *IndexPage[TKey, TValue]) splitChildrenFrom(newIndexPage *IndexPage[TKey, TValue],
-	tree *BTree[TKey, TValue]) {
+func (ip *IndexPage[TKey, TValue]) splitChildrenFrom(newIndexPage *IndexPage[TKey, TValue], at int) {
 
 	// Create a new data page and copy second half data
-	copy(newIndexPage.Children[0:], ip.Children[tree.MidPoint+1:])
-	for i := tree.MidPoint + 1; i < tree.Order+1; i++ {
+	copy(newIndexPage.Children[0:], ip.Children[at+1:at+newIndexPage.Count+2])
+	for i := at + 1; i < len(ip.Children); i++ {
 		ip.deleteChildAt(i)
 	}
 }
 
+func (ip *IndexPage[TKey, TValue]) childIndexOf(offset int) int {
+	for i := 0; i <= ip.Count; i++ {
+		if ip.Children[i] == offset {
+			return i
+		}
+	}
+	return -1
+}
+
 func (ip *IndexPage[TKey, TValue]) deleteAtIndexAndSort(index int) {
 	copy(ip.Container[index:], ip.Container[index+1:])
+	ip.Container[len(ip.Container)-1] = IndexNode[TKey]{}
 	ip.Count--
 }
 
+func (ip *IndexPage[TKey, TValue]) deleteChildAtIndexAndSort(index int) {
+	copy(ip.Children[index:], ip.Children[index+1:])
+	ip.Children[len(ip.Children)-1] = -1
+}
+
 func (ip *IndexPage[TKey, TValue]) getRangesIn(sortedKeys []TKey, lower, upper int) map[int][2]int {
 	var result = make(map[int][2]int)
 
@@ -149,7 +193,7 @@ func (ip *IndexPage[TKey, TValue]) getRangesIn(sortedKeys []TKey, lower, upper i
 	var start = lower
 
 	for keyPointer < upper && indexPointer < ip.Count {
-		if utils.Compare(ip.Container[indexPointer].Key, sortedKeys[keyPointer]) < 0 {
+		if ip.tree.Compare(ip.Container[indexPointer].Key, sortedKeys[keyPointer]) < 0 {
 			result[indexPointer] = [2]int{start, keyPointer + 1}
 			start = keyPointer + 1
 			indexPointer++
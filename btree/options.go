@@ -0,0 +1,138 @@
+package btree
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// MinBlockSize is the smallest index or data block a tree is created with, metadata blocks are at least
+// DefaultMetadataSize long.
+const MinBlockSize = 512
+
+// MinOrder is the smallest order a tree is created with.
+const MinOrder = 3
+
+// Options choose the layout of a tree when it is created. The layout is recorded in the metadata and a tree is
+// always reopened with it. Zero block sizes take their default.
+type Options struct {
+	Order int
+
+	// Name of the registered Comparator ordering the keys, BinaryComparator when empty
+	Comparator string
+
+	// Components of CompositeKey keys ordered from the largest to the smallest value, e.g. {false, true} for keys
+	// ordered by their first component ascending and their second descending, or {true} for a tree of other keys
+	// ordered descending. Components beyond its length are ascending.
+	Descending []bool
+
+	// Block sizes of the metadata, the index pages and the data pages, their default when zero. A page whose encoding
+	// outgrows its block is split by size rather than by count, one still too large, e.g. holding a very long text
+	// key, bleeds the rest of its encoding into a chain of overflow blocks.
+	MetadataSize   int
+	IndexBlockSize int
+	PageBlockSize  int
+
+	// Settings of the layer storing the tree, recorded in the metadata along with the layout
+	Attributes map[string]string
+}
+
+// withDefaults fills the zero block sizes of options with their default.
+func (options Options) withDefaults() Options {
+	if options.MetadataSize == 0 {
+		options.MetadataSize = DefaultMetadataSize
+	}
+	if options.IndexBlockSize == 0 {
+		options.IndexBlockSize = DefaultIndexBlockSize
+	}
+	if options.PageBlockSize == 0 {
+		options.PageBlockSize = DefaultPageBlockSize
+	}
+	if options.Comparator == "" {
+		options.Comparator = BinaryComparator
+	}
+	return options
+}
+
+// validate returns ErrInvalidOptions when a tree cannot be created with options.
+func (options Options) validate() error {
+	if options.Order < MinOrder {
+		return fmt.Errorf("%w: order %d is below %d", ErrInvalidOptions, options.Order, MinOrder)
+	}
+	if _, ok := LookupComparator(options.Comparator); !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownComparator, options.Comparator)
+	}
+	sizes := []struct {
+		name      string
+		size, min int
+	}{
+		{"metadata", options.MetadataSize, DefaultMetadataSize},
+		{"index block", options.IndexBlockSize, MinBlockSize},
+		{"data block", options.PageBlockSize, MinBlockSize},
+	}
+	for _, block := range sizes {
+		if block.size < block.min {
+			return fmt.Errorf("%w: %s size %d is below %d", ErrInvalidOptions, block.name, block.size, block.min)
+		}
+	}
+	return nil
+}
+
+// Options returns the options the tree was created with.
+func (tree *BTree[TKey, TValue]) Options() Options {
+	return Options{
+		Order:          tree.Order,
+		MetadataSize:   tree.MetadataSize,
+		IndexBlockSize: tree.IndexBlockSize,
+		PageBlockSize:  tree.PageBlockSize,
+		Attributes:     maps.Clone(tree.Attributes),
+		Comparator:     tree.Comparator,
+		Descending:     slices.Clone(tree.Descending),
+	}
+}
+
+// comparator returns the registered comparator named by valid options.
+func (options Options) comparator() Comparator {
+	comparator, _ := LookupComparator(options.Comparator)
+	return comparator
+}
+
+// CheckOptions returns ErrOptionsMismatch when the order, a block size, the comparator or the descending components
+// of options are set and differ from what the tree was created with. Attributes are left for the layer storing the
+// tree to check.
+func (tree *BTree[TKey, TValue]) CheckOptions(options Options) error {
+	fields := []struct {
+		name                string
+		requested, recorded int
+	}{
+		{"order", options.Order, tree.Order},
+		{"metadata size", options.MetadataSize, tree.MetadataSize},
+		{"index block size", options.IndexBlockSize, tree.IndexBlockSize},
+		{"data block size", options.PageBlockSize, tree.PageBlockSize},
+	}
+	for _, field := range fields {
+		if field.requested != 0 && field.requested != field.recorded {
+			return fmt.Errorf("%w: %s %d requested, %s records %d",
+				ErrOptionsMismatch, field.name, field.requested, tree.IndexName, field.recorded)
+		}
+	}
+	if recorded := cmp.Or(tree.Comparator, BinaryComparator); options.Comparator != "" && options.Comparator != recorded {
+		return fmt.Errorf("%w: comparator %q requested, %s records %q",
+			ErrOptionsMismatch, options.Comparator, tree.IndexName, recorded)
+	}
+	if options.Descending != nil && !slices.Equal(options.Descending, tree.Descending) {
+		return fmt.Errorf("%w: descending components %v requested, %s records %v",
+			ErrOptionsMismatch, options.Descending, tree.IndexName, tree.Descending)
+	}
+	return nil
+}
+
+// useLayout fills in the block sizes of metadata written before they were recorded, when they were fixed.
+func (tree *BTree[TKey, TValue]) useLayout() {
+	if tree.MetadataSize == 0 {
+		tree.MetadataSize = DefaultMetadataSize
+		tree.IndexBlockSize = DefaultIndexBlockSize
+		tree.PageBlockSize = DefaultPageBlockSize
+	}
+}
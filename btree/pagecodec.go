@@ -0,0 +1,197 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+)
+
+// PageCodec encodes the data, index and free pages of a tree into the blocks of its index file. The codec is chosen
+// when a tree is created and its name is recorded in the metadata, which is always gob encoded, so that the tree is
+// reopened with the codec it was written with. Trees recorded without a codec gob encode their pages.
+type PageCodec interface {
+	Name() string
+	EncodePage(image *PageImage) ([]byte, error)
+	DecodePage(data []byte, image *PageImage) error
+}
+
+// PageImage is the form of a page handed to a PageCodec, independent of the key and value types of the tree. Only
+// the occupied slots of the container are listed.
+type PageImage struct {
+	Kind               string // DataPageKind, IndexPageKind or FreePageKind
+	Count              int
+	Length             int // Length of the container, occupied or not
+	Slots              []PageSlot
+	Children           []int
+	IsChildrenDataPage bool
+	Next, Previous     int
+	Parent, Offset     int
+}
+
+type PageSlot struct {
+	Index int
+	Key   any
+	Value any // Nil on index pages
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]PageCodec{}
+)
+
+// RegisterCodec makes codec available to the trees recording its name. Like gob.Register it is meant to be called
+// from an init function and panics when another codec is registered under the same name.
+func RegisterCodec(codec PageCodec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	if _, ok := codecs[codec.Name()]; ok {
+		panic(fmt.Sprintf("btree: page codec %q registered twice", codec.Name()))
+	}
+	codecs[codec.Name()] = codec
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (PageCodec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	codec, ok := codecs[name]
+	return codec, ok
+}
+
+// PageCodec returns the codec the pages of the tree are written with, nil when they are gob encoded.
+func (tree *BTree[TKey, TValue]) PageCodec() PageCodec {
+	return tree.codec
+}
+
+// useCodec resolves the codec recorded in metadata read from an index file.
+func (tree *BTree[TKey, TValue]) useCodec() error {
+	if tree.Codec == "" {
+		return nil
+	}
+	codec, ok := LookupCodec(tree.Codec)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCodec, tree.Codec)
+	}
+	tree.codec = codec
+	return nil
+}
+
+// encodePage encodes page with codec, metadata and the pages of trees without a codec are gob encoded.
+func encodePage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](codec PageCodec, page TPageBlock) ([]byte, error) {
+	if _, isMetadata := any(page).(*BTree[TKey, TValue]); codec == nil || isMetadata {
+		binBytes := new(bytes.Buffer)
+		if err := gob.NewEncoder(binBytes).Encode(page); err != nil {
+			return nil, err
+		}
+		return binBytes.Bytes(), nil
+	}
+	return codec.EncodePage(pageImage[TKey, TValue](page))
+}
+
+// decodePage decodes data written by encodePage with the same codec into page.
+func decodePage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](codec PageCodec, page TPageBlock, data []byte) error {
+	if _, isMetadata := any(page).(*BTree[TKey, TValue]); codec == nil || isMetadata {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(page)
+	}
+
+	image := PageImage{}
+	if err := codec.DecodePage(data, &image); err != nil {
+		return err
+	}
+	return restoreImage[TKey, TValue](page, &image)
+}
+
+func pageImage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock) *PageImage {
+	switch source := any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		image := &PageImage{
+			Kind: DataPageKind, Count: source.Count, Length: len(source.Container),
+			Next: source.Next, Previous: source.Previous, Parent: source.Parent, Offset: source.Offset,
+			Slots: make([]PageSlot, 0, source.Count),
+		}
+		for i, node := range source.Container {
+			if node.Exists {
+				image.Slots = append(image.Slots, PageSlot{Index: i, Key: node.Key, Value: node.Value})
+			}
+		}
+		return image
+	case *IndexPage[TKey, TValue]:
+		image := &PageImage{
+			Kind: IndexPageKind, Count: source.Count, Length: len(source.Container),
+			Next: source.Next, Previous: source.Previous, Parent: source.Parent, Offset: source.Offset,
+			Children: source.Children, IsChildrenDataPage: source.IsChildrenDataPage,
+			Slots: make([]PageSlot, 0, source.Count),
+		}
+		for i, node := range source.Container {
+			if node.Exists {
+				image.Slots = append(image.Slots, PageSlot{Index: i, Key: node.Key})
+			}
+		}
+		return image
+	default:
+		return &PageImage{Kind: FreePageKind, Next: any(page).(*FreePage).Next}
+	}
+}
+
+func restoreImage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock, image *PageImage) error {
+	if kind := pageKind[TKey, TValue](page); image.Kind != kind {
+		return fmt.Errorf("block holds a %s page", image.Kind)
+	}
+
+	switch target := any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		*target = DataPage[TKey, TValue]{
+			Count: image.Count, Container: make([]DataNode[TKey, TValue], image.Length),
+			Next: image.Next, Previous: image.Previous, Parent: image.Parent, Offset: image.Offset,
+		}
+		for _, slot := range image.Slots {
+			key, ok := typedSlot[TKey](slot.Key)
+			if !ok || slot.Index < 0 || slot.Index >= image.Length {
+				return fmt.Errorf("slot %d holds a key of type %T", slot.Index, slot.Key)
+			}
+			value, ok := typedSlot[TValue](slot.Value)
+			if !ok {
+				return fmt.Errorf("slot %d holds a value of type %T", slot.Index, slot.Value)
+			}
+			target.Container[slot.Index] = newDataNode(key, value)
+		}
+	case *IndexPage[TKey, TValue]:
+		*target = IndexPage[TKey, TValue]{
+			Count: image.Count, Container: make([]IndexNode[TKey], image.Length),
+			Next: image.Next, Previous: image.Previous, Parent: image.Parent, Offset: image.Offset,
+			Children: image.Children, IsChildrenDataPage: image.IsChildrenDataPage,
+		}
+		for _, slot := range image.Slots {
+			key, ok := typedSlot[TKey](slot.Key)
+			if !ok || slot.Index < 0 || slot.Index >= image.Length {
+				return fmt.Errorf("slot %d holds a key of type %T", slot.Index, slot.Key)
+			}
+			target.Container[slot.Index] = newIndexNode(key)
+		}
+	case *FreePage:
+		target.Next = image.Next
+	}
+	return nil
+}
+
+// typedSlot converts a key or value of an image back to the type of the tree, nil standing for the zero value.
+func typedSlot[T any](value any) (T, bool) {
+	if value == nil {
+		var zero T
+		return zero, true
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// encodedLength returns the length of the encoding of page, which is used to split pages by size.
+func encodedLength[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](tree *BTree[TKey, TValue], page TPageBlock) int {
+	data, err := encodePage[TKey, TValue](tree.codec, page)
+	if err != nil {
+		throw(err)
+	}
+	return len(data)
+}
@@ -0,0 +1,252 @@
+package btree
+
+import (
+	"container/list"
+	"os"
+	"slices"
+	"sync"
+)
+
+// PagePool caches decoded pages of index files keyed by file handle and offset, so that walks through hot pages
+// such as the root and the upper index pages skip reading and decoding them. A page written by a mutation is kept
+// dirty in the pool once its batch is in the write-ahead log, it reaches the index file when it is evicted or the
+// file is flushed. One pool may be shared by any number of trees, but every tree reading a file must use the same
+// pool as the trees writing it. Pages are keyed by handle rather than by name so that a handle left open on a file
+// that was replaced by a compaction keeps seeing the pages of the replaced file.
+type PagePool struct {
+	lock     sync.Mutex
+	capacity int
+	order    *list.List // Front is the most recently used page
+	pages    map[pageKey]*list.Element
+	stats    PoolStats
+
+	// Versions of the blocks for the open snapshots, see Snapshot
+	versions  sync.RWMutex                // Held to apply a batch and to read a block at a snapshot
+	version   uint64                      // Number of batches applied and files retired
+	snapshots map[uint64]int              // Number of open snapshots by version
+	history   map[historyKey][]blockImage // Blocks overwritten while snapshots were open, by ascending until
+	retired   map[*os.File]uint64         // Version at which the name of a file stopped referring to it
+}
+
+type PoolStats struct {
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+	WriteBacks uint64 // Dirty pages written to their file on eviction or checkpoint
+}
+
+type pageKey struct {
+	file   *os.File
+	offset int
+}
+
+type pooledPage struct {
+	key   pageKey
+	page  any    // Decoded page, never modified once pooled
+	block []byte // Encoded block still to be written back, nil when the page is clean
+}
+
+func NewPagePool(capacity int) *PagePool {
+	return &PagePool{
+		capacity:  capacity,
+		order:     list.New(),
+		pages:     map[pageKey]*list.Element{},
+		snapshots: map[uint64]int{},
+		history:   map[historyKey][]blockImage{},
+		retired:   map[*os.File]uint64{},
+	}
+}
+
+func (pool *PagePool) Stats() PoolStats {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	return pool.stats
+}
+
+// get returns the pooled page at offset of file, the page must not be modified.
+func (pool *PagePool) get(file *os.File, offset int) (any, bool) {
+	if pool == nil {
+		return nil, false
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	element, ok := pool.pages[pageKey{file: file, offset: offset}]
+	if !ok {
+		pool.stats.Misses++
+		return nil, false
+	}
+	pool.stats.Hits++
+	pool.order.MoveToFront(element)
+	return element.Value.(*pooledPage).page, true
+}
+
+// put pools a page read from file. A page already pooled is kept, it may have been written in the meantime.
+func (pool *PagePool) put(file *os.File, offset int, page any) error {
+	if pool == nil || page == nil {
+		return nil
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	key := pageKey{file: file, offset: offset}
+	if _, ok := pool.pages[key]; ok {
+		return nil
+	}
+	pool.pages[key] = pool.order.PushFront(&pooledPage{key: key, page: page})
+	return pool.evict()
+}
+
+// putDirty pools a page written to file by a logged batch together with the block to write back later.
+func (pool *PagePool) putDirty(file *os.File, offset int, page any, block []byte) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	key := pageKey{file: file, offset: offset}
+	if element, ok := pool.pages[key]; ok {
+		pool.order.Remove(element)
+	}
+	pool.pages[key] = pool.order.PushFront(&pooledPage{key: key, page: page, block: block})
+	return pool.evict()
+}
+
+// dirtyBlock returns the block still to be written back at offset of file.
+func (pool *PagePool) dirtyBlock(file *os.File, offset int) ([]byte, bool) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	element, ok := pool.pages[pageKey{file: file, offset: offset}]
+	if !ok || element.Value.(*pooledPage).block == nil {
+		return nil, false
+	}
+	return element.Value.(*pooledPage).block, true
+}
+
+// drop forgets the page at offset of file without writing it back, its block is being overwritten.
+func (pool *PagePool) drop(file *os.File, offset int) {
+	if pool == nil {
+		return
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if element, ok := pool.pages[pageKey{file: file, offset: offset}]; ok {
+		pool.order.Remove(element)
+		delete(pool.pages, element.Value.(*pooledPage).key)
+	}
+}
+
+// evict drops the least recently used pages until the pool fits its capacity.
+func (pool *PagePool) evict() error {
+	for pool.order.Len() > pool.capacity {
+		element := pool.order.Back()
+		if err := pool.writeBack(element.Value.(*pooledPage)); err != nil {
+			return err
+		}
+		pool.order.Remove(element)
+		delete(pool.pages, element.Value.(*pooledPage).key)
+		pool.stats.Evictions++
+	}
+	return nil
+}
+
+func (pool *PagePool) writeBack(pooled *pooledPage) error {
+	if pooled.block == nil {
+		return nil
+	}
+	if _, err := pooled.key.file.WriteAt(pooled.block, int64(pooled.key.offset)); err != nil {
+		return err
+	}
+	pooled.block = nil
+	pool.stats.WriteBacks++
+	return nil
+}
+
+// Flush writes the dirty pages of the file file is open on back without syncing it, whichever handle they were
+// written through. It must be called before the last handle on a file is closed.
+func (pool *PagePool) Flush(file *os.File) error {
+	if pool == nil {
+		return nil
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for element := pool.order.Front(); element != nil; element = element.Next() {
+		pooled := element.Value.(*pooledPage)
+		if pooled.key.file.Name() != file.Name() {
+			continue
+		}
+		if err := pool.writeBack(pooled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard drops every page of the file named fileName without writing dirty pages back. It must be called when
+// the file is removed or replaced.
+func (pool *PagePool) Discard(fileName string) {
+	if pool == nil {
+		return
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for element := pool.order.Front(); element != nil; {
+		next := element.Next()
+		if pooled := element.Value.(*pooledPage); pooled.key.file.Name() == fileName {
+			pool.order.Remove(element)
+			delete(pool.pages, pooled.key)
+		}
+		element = next
+	}
+}
+
+// clonePage copies a data, index or free page so that the copy shares no slice with it. Metadata is not pooled
+// and yields nil.
+func clonePage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock) any {
+	switch source := any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		clone := *source
+		clone.tree = nil
+		clone.Container = slices.Clone(source.Container)
+		return &clone
+	case *IndexPage[TKey, TValue]:
+		clone := *source
+		clone.tree = nil
+		clone.Container = slices.Clone(source.Container)
+		clone.Children = slices.Clone(source.Children)
+		return &clone
+	case *FreePage:
+		clone := *source
+		return &clone
+	default:
+		return nil
+	}
+}
+
+// restorePage copies the pooled page into page, reporting false when the pooled page is of another kind.
+func restorePage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](page TPageBlock, pooled any) bool {
+	source, ok := pooled.(TPageBlock)
+	if !ok {
+		return false
+	}
+
+	switch target := any(page).(type) {
+	case *DataPage[TKey, TValue]:
+		*target = *clonePage[TKey, TValue](source).(*DataPage[TKey, TValue])
+	case *IndexPage[TKey, TValue]:
+		*target = *clonePage[TKey, TValue](source).(*IndexPage[TKey, TValue])
+	case *FreePage:
+		*target = *clonePage[TKey, TValue](source).(*FreePage)
+	default:
+		return false
+	}
+	return true
+}
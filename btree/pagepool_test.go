@@ -0,0 +1,77 @@
+package btree
+
+import "testing"
+
+func TestPagePoolStats(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 9; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(key int) {
+		t.Helper()
+		if value, found, err := tree.Get(key, file); err != nil || !found || *value != key {
+			t.Fatal(key, found, err)
+		}
+	}
+	check := func(step string, want PoolStats) {
+		t.Helper()
+		if got := tree.pool.Stats(); got != want {
+			t.Fatalf("%s: %+v, want %+v", step, got, want)
+		}
+	}
+
+	// Every Get walks the root index page and a data page
+	tree, file = reopen(t, file)
+	tree.UsePagePool(NewPagePool(100))
+	get(0)
+	check("first read", PoolStats{Misses: 2})
+	get(0)
+	check("pages read again", PoolStats{Hits: 2, Misses: 2})
+	get(8)
+	check("other data page", PoolStats{Hits: 3, Misses: 3})
+
+	// A page written stays dirty in the pool until a checkpoint writes it back, once
+	if err = tree.Put(8, 80, file); err != nil {
+		t.Fatal(err)
+	}
+	written := tree.pool.Stats()
+	if written.Misses != 3 || written.WriteBacks != 0 {
+		t.Fatalf("put into a pooled data page: %+v", written)
+	}
+	if err = Checkpoint(file, tree.pool); err != nil {
+		t.Fatal(err)
+	}
+	written.WriteBacks = 1
+	check("checkpoint", written)
+	if err = Checkpoint(file, tree.pool); err != nil {
+		t.Fatal(err)
+	}
+	check("second checkpoint", written)
+
+	// A pool of one page evicts the root for the data page and the data page for the root
+	tree.UsePagePool(NewPagePool(1))
+	get(0)
+	check("evicted root", PoolStats{Misses: 2, Evictions: 1})
+	get(0)
+	check("evicted again", PoolStats{Misses: 4, Evictions: 3})
+	// A dirty page is written back when it is evicted
+	if err = tree.Put(9, 9, file); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.pool.Stats(); got.WriteBacks == 0 || got.WriteBacks > got.Evictions {
+		t.Fatalf("put through a full pool: %+v", got)
+	}
+	if err = Checkpoint(file, tree.pool); err != nil {
+		t.Fatal(err)
+	}
+	tree, file = reopen(t, file)
+	if entries := keysOf(t, tree, file); len(entries) != 10 || entries[8] != 80 {
+		t.Fatal(len(entries), "entries after eviction and checkpoint")
+	}
+}
@@ -0,0 +1,285 @@
+package btree
+
+import "os"
+
+// A Snapshot pins the blocks of the files sharing a PagePool as they are when it is taken. Every batch applied
+// afterwards keeps a copy of the blocks it overwrites in the pool, so that a tree viewed with AtSnapshot keeps
+// reading the pages of that moment while others are written. The copies are dropped once no snapshot older than
+// them is open, a snapshot must be released for its copies not to pile up.
+type Snapshot struct {
+	pool     *PagePool
+	version  uint64
+	released bool
+}
+
+type historyKey struct {
+	name   string
+	offset int
+}
+
+// blockImage is a block as it was before the batch of version until overwrote it.
+type blockImage struct {
+	until uint64
+	block []byte
+}
+
+// Snapshot takes a snapshot of the files of the pool, nil for a nil pool.
+func (pool *PagePool) Snapshot() *Snapshot {
+	if pool == nil {
+		return nil
+	}
+
+	pool.versions.Lock()
+	defer pool.versions.Unlock()
+
+	pool.snapshots[pool.version]++
+	return &Snapshot{pool: pool, version: pool.version}
+}
+
+// HasSnapshots reports whether a snapshot of the files of the pool is open.
+func (pool *PagePool) HasSnapshots() bool {
+	if pool == nil {
+		return false
+	}
+
+	pool.versions.RLock()
+	defer pool.versions.RUnlock()
+
+	return len(pool.snapshots) > 0
+}
+
+// SnapshotBefore reports whether a snapshot taken before version is open.
+func (pool *PagePool) SnapshotBefore(version uint64) bool {
+	if pool == nil {
+		return false
+	}
+
+	pool.versions.RLock()
+	defer pool.versions.RUnlock()
+
+	oldest, ok := pool.oldestSnapshot()
+	return ok && oldest < version
+}
+
+// Retire tells the pool that the name file is open on no longer refers to it, because the file was removed or
+// replaced by a compaction, and returns the version it is retired at. Snapshots taken before keep reading the
+// blocks of file through it, while the blocks written under its name afterwards belong to the new file. The dirty
+// pages of file must have been flushed.
+func (pool *PagePool) Retire(file *os.File) uint64 {
+	if pool == nil {
+		return 0
+	}
+
+	pool.versions.Lock()
+	defer pool.versions.Unlock()
+
+	pool.version++
+	if len(pool.snapshots) > 0 {
+		pool.retired[file] = pool.version
+	}
+	return pool.version
+}
+
+func (snapshot *Snapshot) Version() uint64 {
+	return snapshot.version
+}
+
+// Released reports whether Release was called, a nil snapshot counts as released.
+func (snapshot *Snapshot) Released() bool {
+	if snapshot == nil {
+		return true
+	}
+
+	snapshot.pool.versions.RLock()
+	defer snapshot.pool.versions.RUnlock()
+
+	return snapshot.released
+}
+
+// Release closes the snapshot, trees viewed at it fail with ErrSnapshotReleased afterwards. It may be called more
+// than once.
+func (snapshot *Snapshot) Release() {
+	if snapshot == nil {
+		return
+	}
+	pool := snapshot.pool
+
+	pool.versions.Lock()
+	defer pool.versions.Unlock()
+
+	if snapshot.released {
+		return
+	}
+	snapshot.released = true
+	if pool.snapshots[snapshot.version]--; pool.snapshots[snapshot.version] == 0 {
+		delete(pool.snapshots, snapshot.version)
+	}
+	pool.prune()
+}
+
+// oldestSnapshot returns the version of the oldest open snapshot, callers hold versions.
+func (pool *PagePool) oldestSnapshot() (uint64, bool) {
+	var oldest uint64
+	found := false
+	for version := range pool.snapshots {
+		if !found || version < oldest {
+			oldest, found = version, true
+		}
+	}
+	return oldest, found
+}
+
+// prune drops the blocks and the retired files no open snapshot reads any more, callers hold versions.
+func (pool *PagePool) prune() {
+	oldest, ok := pool.oldestSnapshot()
+	if !ok {
+		clear(pool.history)
+		clear(pool.retired)
+		return
+	}
+
+	for key, images := range pool.history {
+		kept := 0
+		for kept < len(images) && images[kept].until <= oldest {
+			kept++
+		}
+		if kept == len(images) {
+			delete(pool.history, key)
+		} else if kept > 0 {
+			pool.history[key] = images[kept:]
+		}
+	}
+	for file, version := range pool.retired {
+		if version <= oldest {
+			delete(pool.retired, file)
+		}
+	}
+}
+
+// preserve counts a batch written to file as a new version and keeps a copy of the blocks it overwrites while
+// snapshots are open, callers hold versions.
+func (pool *PagePool) preserve(file *os.File, batch *pageBatch) error {
+	pool.version++
+	if len(pool.snapshots) == 0 {
+		return nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	for offset, page := range batch.pages {
+		block, ok := pool.dirtyBlock(file, offset)
+		if !ok {
+			if int64(offset) >= info.Size() {
+				// A block past the end of the file was never read by a snapshot
+				continue
+			}
+			if block, err = readBlock(file, offset, len(page)); err != nil {
+				return err
+			}
+		}
+		key := historyKey{name: file.Name(), offset: offset}
+		pool.history[key] = append(pool.history[key], blockImage{until: pool.version, block: block})
+	}
+	return nil
+}
+
+// block returns the block at offset of file as it was when the snapshot was taken, or false when file still holds
+// it, callers hold versions for reading.
+func (snapshot *Snapshot) block(file *os.File, offset int) ([]byte, bool) {
+	pool := snapshot.pool
+	retiredAt, retired := pool.retired[file]
+	for _, image := range pool.history[historyKey{name: file.Name(), offset: offset}] {
+		if retired && image.until >= retiredAt {
+			break
+		}
+		if image.until > snapshot.version {
+			return image.block, true
+		}
+	}
+	return nil, false
+}
+
+// AtSnapshot returns a copy of the tree reading its pages as they were when snapshot was taken, e.g. for a long walk
+// alongside writers. It must be called while the tree is not written, so that its metadata matches the snapshot,
+// and the copy must not be written. A nil snapshot returns the tree itself.
+func (tree *BTree[TKey, TValue]) AtSnapshot(snapshot *Snapshot) *BTree[TKey, TValue] {
+	if snapshot == nil {
+		return tree
+	}
+	view := *tree
+	view.snapshot = snapshot
+	return &view
+}
+
+// readSnapshotPage reads a block of the tree as it was when the snapshot of the tree was taken: the copy kept by
+// the pool when the block was overwritten since, or else the block read like readPage.
+func readSnapshotPage[TKey, TValue any, TPageBlock PageBlock[TKey, TValue]](tree *BTree[TKey, TValue], page TPageBlock, file *os.File, offset int, length int) (TPageBlock, error) {
+	snapshot := tree.snapshot
+	pool := snapshot.pool
+	pool.versions.RLock()
+	defer pool.versions.RUnlock()
+
+	if snapshot.released {
+		return page, ErrSnapshotReleased
+	}
+	readBleed := func(offset int) ([]byte, error) {
+		if block, ok := snapshot.block(file, offset); ok {
+			return block, nil
+		}
+		return readBlock(file, offset, length)
+	}
+
+	if block, ok := snapshot.block(file, offset); ok {
+		return decodeBlock[TKey, TValue](tree.codec, page, block, offset, readBleed)
+	}
+
+	if pooled, ok := pool.get(file, offset); ok {
+		if !restorePage[TKey, TValue](page, pooled) {
+			return page, ErrCorruptPage{Offset: offset, Kind: pageKind[TKey, TValue](page), Reason: "block holds another kind of page"}
+		}
+		return page, nil
+	}
+
+	page, err := readAt[TKey, TValue](tree.codec, page, file, offset, length, readBleed)
+	if err != nil {
+		return page, err
+	}
+	return page, pool.put(file, offset, clonePage[TKey, TValue](page))
+}
+
+// ReadMetadataAt reads the metadata of the tree in file as it was when snapshot was taken, the returned tree reads
+// its pages at the snapshot. A nil snapshot reads the current metadata like ReadMetadata.
+func ReadMetadataAt[TKey, TValue any](file *os.File, snapshot *Snapshot) (*BTree[TKey, TValue], error) {
+	if snapshot == nil {
+		return ReadMetadata[TKey, TValue](file)
+	}
+	pool := snapshot.pool
+
+	pool.versions.RLock()
+	if snapshot.released {
+		pool.versions.RUnlock()
+		return nil, ErrSnapshotReleased
+	}
+	var page BTree[TKey, TValue]
+	var metadata *BTree[TKey, TValue]
+	var err error
+	if block, ok := snapshot.block(file, 0); ok {
+		metadata, err = decodeBlock[TKey, TValue](nil, &page, block, 0, func(offset int) ([]byte, error) {
+			return readBlock(file, offset, len(block))
+		})
+	} else {
+		metadata, err = readMetadata[TKey, TValue](file)
+	}
+	pool.versions.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = metadata.useMetadata(); err != nil {
+		return nil, err
+	}
+	metadata.snapshot = snapshot
+	return metadata, nil
+}
@@ -0,0 +1,262 @@
+package btree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+)
+
+// Every mutation of a tree is logged as one batch of page images to a write-ahead log next to the index file:
+//
+//	magic "SWAL" | page count uint32 | (offset int64 | length uint32 | page bytes)... | crc32c of the batch
+//
+// The log is fsynced before the pages are written to the index file. Batches are replayed in order when a tree is
+// opened, a batch cut short by a crash fails its checksum and is dropped together with everything after it.
+
+const (
+	walMagic = "SWAL"
+
+	// Once the log grows past this size the index file is synced and the log truncated
+	WalCheckpointSize = 4 * 1024 * 1024
+)
+
+func WalFile(indexName string) string {
+	return indexName + ".wal"
+}
+
+// pageBatch holds the page images written by a mutation in progress.
+type pageBatch struct {
+	pages   map[int][]byte
+	decoded map[int]any // Copies of the pages to pool once the batch is logged
+}
+
+func (batch *pageBatch) put(offset int, page []byte, decoded any) {
+	batch.pages[offset] = page
+	batch.decoded[offset] = decoded
+}
+
+func (batch *pageBatch) get(offset int) ([]byte, bool) {
+	if batch == nil {
+		return nil, false
+	}
+	page, ok := batch.pages[offset]
+	return page, ok
+}
+
+func (batch *pageBatch) encode() []byte {
+	offsets := make([]int, 0, len(batch.pages))
+	for offset := range batch.pages {
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+
+	record := []byte(walMagic)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(offsets)))
+	for _, offset := range offsets {
+		record = binary.LittleEndian.AppendUint64(record, uint64(offset))
+		record = binary.LittleEndian.AppendUint32(record, uint32(len(batch.pages[offset])))
+		record = append(record, batch.pages[offset]...)
+	}
+	return binary.LittleEndian.AppendUint32(record, crc32.Checksum(record, checksumTable))
+}
+
+// atomically runs mutate with every page write collected into a batch which is logged and applied as a whole
+// once mutate returns. If mutate fails or the batch cannot be logged nothing reaches the index file and the
+// metadata of the tree is rolled back. Once the batch is logged the mutation is durable, a failure to apply it to
+// the index file is still returned but the pages are only repaired by ReplayLog when the index is opened again.
+func (tree *BTree[TKey, TValue]) atomically(file *os.File, mutate func()) {
+	if tree.batch != nil {
+		// Already part of a mutation in progress
+		mutate()
+		return
+	}
+
+	metadata := *tree
+	tree.batch = &pageBatch{pages: map[int][]byte{}, decoded: map[int]any{}}
+	defer func() {
+		if tree.batch != nil {
+			*tree = metadata
+		}
+	}()
+
+	mutate()
+
+	batch := tree.batch
+	if len(batch.pages) > 0 {
+		if err := logBatch(batch, file); err != nil {
+			throw(err)
+		}
+	}
+	tree.batch = nil
+	if err := applyBatch(batch, file, tree.pool); err != nil {
+		throw(err)
+	}
+}
+
+// logBatch appends batch to the write-ahead log of file. A batch that could not be logged completely is cut
+// off again so that it is never replayed.
+func logBatch(batch *pageBatch, file *os.File) error {
+	wal, err := os.OpenFile(WalFile(file.Name()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	walInfo, err := wal.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = wal.Write(batch.encode()); err == nil {
+		err = wal.Sync()
+	}
+	if err != nil {
+		wal.Truncate(walInfo.Size())
+	}
+	return err
+}
+
+// applyBatch writes the pages of a logged batch to file and checkpoints the log once it has grown too large.
+// Pages are left dirty in pool when one is given, only the metadata is written through.
+func applyBatch(batch *pageBatch, file *os.File, pool *PagePool) error {
+	if len(batch.pages) == 0 {
+		return nil
+	}
+	if pool != nil {
+		// Snapshots read the blocks as they were while the batch is installed
+		pool.versions.Lock()
+		defer pool.versions.Unlock()
+		if err := pool.preserve(file, batch); err != nil {
+			return err
+		}
+	}
+
+	for offset, page := range batch.pages {
+		if decoded := batch.decoded[offset]; pool != nil && decoded != nil {
+			if err := pool.putDirty(file, offset, decoded, page); err != nil {
+				return err
+			}
+			continue
+		}
+		// A pooled copy of what the block held before must not be written back over it
+		pool.drop(file, offset)
+		if _, err := file.WriteAt(page, int64(offset)); err != nil {
+			return err
+		}
+	}
+
+	walInfo, err := os.Stat(WalFile(file.Name()))
+	if err != nil {
+		return err
+	}
+	if walInfo.Size() >= WalCheckpointSize {
+		return Checkpoint(file, pool)
+	}
+	return nil
+}
+
+// checkpoint makes the pages applied to the index file durable so that the log can be dropped.
+func checkpoint(file *os.File, wal *os.File) error {
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := wal.Truncate(0); err != nil {
+		return err
+	}
+	return wal.Sync()
+}
+
+// Checkpoint writes the dirty pages of file in pool back, syncs file and truncates its write-ahead log. pool may
+// be nil for a tree without a page pool.
+func Checkpoint(file *os.File, pool *PagePool) error {
+	if err := pool.Flush(file); err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(WalFile(file.Name()), os.O_RDWR, os.ModePerm)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	return checkpoint(file, wal)
+}
+
+// ReplayLog applies the complete batches of the write-ahead log of file to it and truncates the log. It must be
+// called before the metadata of the tree is read.
+func ReplayLog(file *os.File) error {
+	wal, err := os.OpenFile(WalFile(file.Name()), os.O_RDWR, os.ModePerm)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	walInfo, err := wal.Stat()
+	if err != nil {
+		return err
+	}
+	reader := &io.LimitedReader{R: bufio.NewReader(wal), N: walInfo.Size()}
+	for {
+		pages, ok := readBatch(reader)
+		if !ok {
+			break
+		}
+		for offset, page := range pages {
+			if _, err = file.WriteAt(page, int64(offset)); err != nil {
+				return err
+			}
+		}
+	}
+	return checkpoint(file, wal)
+}
+
+// readBatch reads the next batch of the log, reporting false at the end of the log or at a torn batch. The lengths
+// read from a torn batch are garbage, so they are checked against what is left of the log before anything is
+// allocated.
+func readBatch(reader *io.LimitedReader) (map[int][]byte, bool) {
+	hash := crc32.New(checksumTable)
+	record := io.TeeReader(reader, hash)
+
+	header := make([]byte, len(walMagic)+4)
+	if _, err := io.ReadFull(record, header); err != nil || string(header[:len(walMagic)]) != walMagic {
+		return nil, false
+	}
+
+	pages := map[int][]byte{}
+	pageCount := binary.LittleEndian.Uint32(header[len(walMagic):])
+	if int64(pageCount)*12 > reader.N {
+		return nil, false
+	}
+	for i := uint32(0); i < pageCount; i++ {
+		pageHeader := make([]byte, 12)
+		if _, err := io.ReadFull(record, pageHeader); err != nil {
+			return nil, false
+		}
+		length := binary.LittleEndian.Uint32(pageHeader[8:])
+		if int64(length) > reader.N {
+			return nil, false
+		}
+		page := make([]byte, length)
+		if _, err := io.ReadFull(record, page); err != nil {
+			return nil, false
+		}
+		pages[int(binary.LittleEndian.Uint64(pageHeader[:8]))] = page
+	}
+
+	checksum := hash.Sum32()
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(reader, trailer); err != nil || binary.LittleEndian.Uint32(trailer) != checksum {
+		return nil, false
+	}
+	return pages, true
+}
@@ -0,0 +1,177 @@
+package btree
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// crashPut logs the batch of a put of key and writes only every other page of it to the index file, as if the
+// process died while applying the batch.
+func crashPut(t *testing.T, tree *BTree[int, int], file *os.File, key int) {
+	t.Helper()
+	tree.batch = &pageBatch{pages: map[int][]byte{}, decoded: map[int]any{}}
+	tree.put(key, key, file)
+	batch := tree.batch
+	tree.batch = nil
+	if err := logBatch(batch, file); err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	for offset, page := range batch.pages {
+		if i%2 == 0 {
+			if _, err := file.WriteAt(page, int64(offset)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		i++
+	}
+}
+
+// reopen closes file and opens it again after replaying its write-ahead log.
+func reopen(t *testing.T, file *os.File) (*BTree[int, int], *os.File) {
+	t.Helper()
+	file.Close()
+	file, err := os.OpenFile(file.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	if err = ReplayLog(file); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ReadMetadata[int, int](file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, file
+}
+
+func TestReplayLogRepairsInterruptedPuts(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := rand.New(rand.NewSource(1)).Perm(200)
+	for _, key := range keys[:100] {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, key := range keys[100:110] {
+		crashPut(t, tree, file, key)
+		tree, file = reopen(t, file)
+		if got := keysOf(t, tree, file); len(got) != 101+i || tree.Count != 101+i {
+			t.Fatalf("%d keys after replaying put %d, count %d", len(got), i, tree.Count)
+		}
+	}
+	info, err := os.Stat(WalFile(file.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatal("log not truncated after replay", info.Size())
+	}
+}
+
+func TestReplayLogDropsTornBatch(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 50; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A batch cut short while it was logged never reaches the index file
+	tree.batch = &pageBatch{pages: map[int][]byte{}, decoded: map[int]any{}}
+	tree.put(50, 50, file)
+	record := tree.batch.encode()
+	tree.batch = nil
+	wal, err := os.OpenFile(WalFile(file.Name()), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wal.Write(record[:len(record)-7]); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	tree, file = reopen(t, file)
+	if got := keysOf(t, tree, file); len(got) != 50 || tree.Count != 50 {
+		t.Fatalf("%d keys after replaying a torn batch, count %d", len(got), tree.Count)
+	}
+}
+
+func TestReplayLogBoundsTornLengths(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 20; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Torn tails whose page count or page length is garbage
+	for _, pageCount := range []uint32{1, math.MaxUint32} {
+		tail := binary.LittleEndian.AppendUint32([]byte(walMagic), pageCount)
+		tail = binary.LittleEndian.AppendUint64(tail, 0)
+		tail = binary.LittleEndian.AppendUint32(tail, math.MaxUint32)
+		if err = os.WriteFile(WalFile(file.Name()), append(tail, "page"...), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		tree, file = reopen(t, file)
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<24 {
+			t.Fatalf("page count %d: replay allocated %d bytes", pageCount, allocated)
+		}
+		if got := keysOf(t, tree, file); len(got) != 20 {
+			t.Fatalf("page count %d: %d keys after replaying a torn tail", pageCount, len(got))
+		}
+	}
+}
+
+func TestAtomicallyRollsBackFailedMutation(t *testing.T) {
+	file := openTestFile(t)
+	tree, err := NewTree[int, int](file.Name(), Options{Order: 4}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 50; key++ {
+		if err = tree.Put(key, key, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	func() {
+		defer catch(&err)
+		tree.atomically(file, func() {
+			tree.put(1000, 1000, file)
+			throw(os.ErrInvalid)
+		})
+	}()
+	if err != os.ErrInvalid {
+		t.Fatal(err)
+	}
+	if tree.Count != 50 || tree.batch != nil {
+		t.Fatal("mutation not rolled back", tree.Count)
+	}
+	if got := keysOf(t, tree, file); len(got) != 50 {
+		t.Fatal(len(got), "keys after rollback")
+	}
+}
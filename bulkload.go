@@ -0,0 +1,211 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"bptree/dbmodels"
+	"bptree/utils"
+	"errors"
+	"os"
+)
+
+// BulkIterator yields the rows of a bulk load ordered by key in the order of the index and, within a key, by primary
+// key.
+type BulkIterator interface {
+	HasNext() bool
+	Next() (key any, primaryKey any, page *dbmodels.Page, err error)
+}
+
+// BulkLoad builds the empty index bottom-up from rows instead of putting them one by one, creating the sub index
+// file of every key holding at least SubTreeThreshold rows in the same pass. Leaves and index pages are filled to
+// fillFactor of their capacity. The index is written beside the index file and swapped in once complete, so a
+// failed load leaves the index empty. Rows must come sorted by key and primary key, btree.ErrUnsorted is returned
+// when they are out of order or repeat a primary key under the same key, ErrDuplicateKey when rows of a unique index
+// share a key.
+func (tree *Tree) BulkLoad(rows BulkIterator, fillFactor float64) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if _, err := tree.openFile(); err != nil {
+		return err
+	}
+	if !tree.index.IsEmpty() {
+		return ErrIndexNotEmpty
+	}
+
+	compactFile, err := os.OpenFile(btree.CompactFile(tree.indexFile), os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer compactFile.Close()
+
+	entries := &bulkEntries{tree: tree, rows: rows, fillFactor: fillFactor}
+	loaded, err := btree.BulkLoad[any, any](tree.indexFile, tree.index.Options(), tree.index.PageCodec(), compactFile, entries, fillFactor)
+	if err != nil {
+		return errors.Join(err, entries.discard(), tree.index.DiscardCompacted())
+	}
+	loaded.UsePagePool(tree.pool)
+
+	tree.writes++
+	return tree.commitIndex(loaded)
+}
+
+// bulkEntries groups the rows of a bulk load into the entries of the main index, writing the sub index file of
+// every key with too many rows for a map.
+type bulkEntries struct {
+	tree       *Tree
+	rows       BulkIterator
+	fillFactor float64
+	next       *bulkRow // Row read ahead of the entry it belongs to
+	subFiles   []string // Sub index files written so far
+}
+
+type bulkRow struct {
+	key        any
+	primaryKey any
+	page       *dbmodels.Page
+}
+
+func (entries *bulkEntries) HasNext() bool {
+	return entries.next != nil || entries.rows.HasNext()
+}
+
+func (entries *bulkEntries) Next() (any, any, error) {
+	first, err := entries.peek()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := first.key
+	threshold := entries.tree.settings.SubTreeThreshold
+	group := make([]*bulkRow, 0, threshold)
+	for len(group) < threshold {
+		row, err := entries.nextRowOf(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == nil {
+			break
+		}
+		if len(group) > 0 && entries.tree.settings.Unique {
+			return nil, nil, duplicateKeyError(key)
+		}
+		if len(group) > 0 && utils.Compare(group[len(group)-1].primaryKey, row.primaryKey) >= 0 {
+			return nil, nil, btree.ErrUnsorted
+		}
+		group = append(group, row)
+	}
+
+	if len(group) < threshold {
+		value := make(dbmodels.Rows, 0, len(group))
+		for _, row := range group {
+			value = append(value, dbmodels.Row{PrimaryKey: row.primaryKey, Page: row.page})
+		}
+		return key, value, nil
+	}
+
+	subTree, err := entries.loadSubTree(key, group)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, *subTree, nil
+}
+
+// peek reads the next row ahead without consuming it, nil once the rows are exhausted.
+func (entries *bulkEntries) peek() (*bulkRow, error) {
+	if entries.next == nil && entries.rows.HasNext() {
+		key, primaryKey, page, err := entries.rows.Next()
+		if err == nil {
+			err = entries.tree.checkRow(primaryKey, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries.next = &bulkRow{key: key, primaryKey: primaryKey, page: page}
+	}
+	return entries.next, nil
+}
+
+// nextRowOf consumes the next row when it is stored under key, returning nil once the rows move on to another key.
+func (entries *bulkEntries) nextRowOf(key any) (*bulkRow, error) {
+	row, err := entries.peek()
+	if err != nil || row == nil || entries.tree.index.Compare(row.key, key) != 0 {
+		return nil, err
+	}
+	entries.next = nil
+	return row, nil
+}
+
+// loadSubTree writes the rows of key into a fresh sub index file, starting with the rows already read into group.
+func (entries *bulkEntries) loadSubTree(key any, group []*bulkRow) (*btree.BTree[any, *dbmodels.Page], error) {
+	tree := entries.tree
+	indexName, err := SubIndexFile(tree.collectionName, tree.fieldName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leftovers of an earlier index under the same name must not leak into the new file
+	tree.pool.Discard(indexName)
+	tree.subFiles.evict(indexName)
+	if err = os.Remove(btree.WalFile(indexName)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(indexName, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries.subFiles = append(entries.subFiles, indexName)
+
+	rows := &subTreeRows{entries: entries, key: key, group: group}
+	return btree.BulkLoad[any, *dbmodels.Page](indexName, tree.subTreeOptions(), tree.index.PageCodec(), file, rows, entries.fillFactor)
+}
+
+// discard removes the sub index files written by a failed bulk load.
+func (entries *bulkEntries) discard() error {
+	var errs []error
+	for _, indexName := range entries.subFiles {
+		if err := os.Remove(indexName); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// subTreeRows yields the primary keys and pages of the rows of one key for its sub tree.
+type subTreeRows struct {
+	entries *bulkEntries
+	key     any
+	group   []*bulkRow // Rows read before the key turned out to need a sub tree
+	err     error      // Error met while looking ahead, returned by the following Next
+}
+
+func (rows *subTreeRows) HasNext() bool {
+	if len(rows.group) > 0 {
+		return true
+	}
+
+	row, err := rows.entries.peek()
+	if err != nil {
+		rows.err = err
+		return true
+	}
+	return row != nil && rows.entries.tree.index.Compare(row.key, rows.key) == 0
+}
+
+func (rows *subTreeRows) Next() (any, *dbmodels.Page, error) {
+	if rows.err != nil {
+		return nil, nil, rows.err
+	}
+	if len(rows.group) > 0 {
+		row := rows.group[0]
+		rows.group = rows.group[1:]
+		return row.primaryKey, row.page, nil
+	}
+
+	row, err := rows.entries.nextRowOf(rows.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return row.primaryKey, row.page, nil
+}
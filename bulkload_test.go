@@ -0,0 +1,113 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"bptree/dbmodels"
+	"errors"
+	"os"
+	"testing"
+)
+
+// testRow is a row given to BulkLoad by rowSlice.
+type testRow struct {
+	key        any
+	primaryKey any
+	page       *dbmodels.Page
+}
+
+// rowSlice is a BulkIterator over rows.
+type rowSlice struct {
+	rows []testRow
+	next int
+}
+
+func (rows *rowSlice) HasNext() bool {
+	return rows.next < len(rows.rows)
+}
+
+func (rows *rowSlice) Next() (any, any, *dbmodels.Page, error) {
+	row := rows.rows[rows.next]
+	rows.next++
+	return row.key, row.primaryKey, row.page, nil
+}
+
+func TestBulkLoadRejectsUnsupportedKeys(t *testing.T) {
+	for _, row := range []testRow{
+		{key: []int{1}, primaryKey: 1, page: &dbmodels.Page{}},
+		{key: 1, primaryKey: map[int]int{}, page: &dbmodels.Page{}},
+	} {
+		tree := openTestTree(t, Options{})
+		if err := tree.BulkLoad(&rowSlice{rows: []testRow{row}}, 1); !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("%T, %T: %v", row.key, row.primaryKey, err)
+		}
+		if tree.Count() != 0 {
+			t.Fatal("rows loaded", tree.Count())
+		}
+	}
+}
+
+func TestBulkLoadRows(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 4})
+	var rows []testRow
+	for key := 0; key < 200; key++ {
+		// Every tenth key holds enough rows for a sub index
+		count := 1 + key%3
+		if key%10 == 0 {
+			count = 20
+		}
+		for primaryKey := 0; primaryKey < count; primaryKey++ {
+			rows = append(rows, testRow{key: key, primaryKey: key*100 + primaryKey, page: &dbmodels.Page{DataOffset: int64(primaryKey)}})
+		}
+	}
+	if err := tree.BulkLoad(&rowSlice{rows: rows}, 0.8); err != nil {
+		t.Fatal(err)
+	}
+
+	if tree.Count() != 200 {
+		t.Fatal("count", tree.Count())
+	}
+	for _, row := range rows {
+		if page := rowOf(t, tree, row.primaryKey, row.key); page == nil || page.DataOffset != row.page.DataOffset {
+			t.Fatalf("row %v under %v: %v", row.primaryKey, row.key, page)
+		}
+	}
+	for key := 0; key < 200; key++ {
+		_, err := os.Stat(subIndexFileOf(t, key))
+		if hasSubIndex := key%10 == 0; hasSubIndex != (err == nil) {
+			t.Fatalf("key %d: sub index file %v", key, err)
+		}
+	}
+	if err := tree.BulkLoad(&rowSlice{rows: rows}, 0.8); !errors.Is(err, ErrIndexNotEmpty) {
+		t.Fatal("load into a loaded index", err)
+	}
+
+	// The loaded index takes writes and deletes like any other
+	if err := tree.Put(5, 0, &dbmodels.Page{DataOffset: 99}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := tree.Delete(1000, 10); err != nil || !deleted {
+		t.Fatal(deleted, err)
+	}
+	if page := rowOf(t, tree, 5, 0); page == nil || page.DataOffset != 99 {
+		t.Fatal("put after load", page)
+	}
+	if page := rowOf(t, tree, 1000, 10); page != nil {
+		t.Fatal("delete after load", page)
+	}
+}
+
+func TestBulkLoadRejectsUnsortedRows(t *testing.T) {
+	for _, rows := range [][]testRow{
+		{{key: 2, primaryKey: 1}, {key: 1, primaryKey: 1}},
+		{{key: 1, primaryKey: 2}, {key: 1, primaryKey: 1}},
+		{{key: 1, primaryKey: 1}, {key: 1, primaryKey: 1}},
+	} {
+		tree := openTestTree(t, Options{})
+		if err := tree.BulkLoad(&rowSlice{rows: rows}, 1); !errors.Is(err, btree.ErrUnsorted) {
+			t.Fatal(rows, err)
+		}
+		if tree.Count() != 0 {
+			t.Fatal("failed load left rows", tree.Count())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"bptree/utils"
+	"fmt"
+	"strings"
+)
+
+// CompositeKey is the key of a composite index, holding the value of every field of the index in their order.
+type CompositeKey = btree.CompositeKey
+
+// IndexField is a field of a composite index.
+type IndexField struct {
+	Name       string
+	Descending bool // Order the values of the field from the largest to the smallest
+}
+
+// CompositeFieldName returns the field name a composite index over fields is stored under: the names of the fields
+// joined by "+", the name of a descending field prefixed by "-".
+func CompositeFieldName(fields []IndexField) string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name
+		if field.Descending {
+			names[i] = "-" + field.Name
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// NewComposite opens the composite index over fields in collectionName like New. Its keys are CompositeKey values
+// holding a value per field, Put and Update return ErrKeyType for any other key. Seek and the bounds of a KeyRange
+// also take keys holding the values of only the leading fields, e.g. Between(CompositeKey{"fr"}, CompositeKey{"fr"})
+// selects every row whose first field is "fr", and Between(CompositeKey{"fr", from}, CompositeKey{"fr", to}) serves
+// country = ? AND created_at BETWEEN ? AND ? over the fields country and created_at. The bounds of a range are in
+// the order of the index, the lower bound of a descending field holding its largest value. The index is stored in
+// CompositeIndexFile.
+func NewComposite(collectionName string, fields []IndexField, options Options) (*Tree, error) {
+	if err := validateFields(fields); err != nil {
+		return nil, err
+	}
+	if options.Descending {
+		return nil, fmt.Errorf("%w: the fields of a composite index set their own direction", ErrInvalidOptions)
+	}
+
+	descending := make([]bool, len(fields))
+	for i, field := range fields {
+		descending[i] = field.Descending
+	}
+	tree, err := open(collectionName, CompositeFieldName(fields), options, descending)
+	if err != nil {
+		return nil, err
+	}
+	tree.fields = fields
+	return tree, nil
+}
+
+// validateFields returns ErrInvalidOptions when fields cannot make up a composite index.
+func validateFields(fields []IndexField) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: a composite index needs at least a field", ErrInvalidOptions)
+	}
+	names := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field.Name == "" || strings.Contains(field.Name, "+") || strings.HasPrefix(field.Name, "-") {
+			return fmt.Errorf("%w: invalid field name %q", ErrInvalidOptions, field.Name)
+		}
+		if names[field.Name] {
+			return fmt.Errorf("%w: field %q is repeated", ErrInvalidOptions, field.Name)
+		}
+		names[field.Name] = true
+	}
+	return nil
+}
+
+// checkKey returns ErrUnsupportedKey when key, or a value of a composite key, is of a type the index cannot order,
+// and ErrKeyType when key cannot be stored in a composite index.
+func (tree *Tree) checkKey(key any) error {
+	composite, isComposite := key.(CompositeKey)
+	if tree.fields != nil && (!isComposite || len(composite) != len(tree.fields)) {
+		return fmt.Errorf("%w: %v is not a key over %s", ErrKeyType, key, CompositeFieldName(tree.fields))
+	}
+	return checkQueryKey(key)
+}
+
+// checkQueryKey returns ErrUnsupportedKey when key, or a value of a composite key, is of a type the index cannot
+// order. Unlike checkKey it accepts the leading fields of a composite key, which queries seek by.
+func checkQueryKey(key any) error {
+	composite, isComposite := key.(CompositeKey)
+	if !isComposite {
+		return checkKeyValue(key)
+	}
+	for _, value := range composite {
+		if err := checkKeyValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKeyValue returns ErrUnsupportedKey when value is of a type utils.Compare does not order, e.g. a struct or an
+// array, which would otherwise panic deep inside the index.
+func checkKeyValue(value any) error {
+	if !utils.IsKey(value) {
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, value)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// primaryKeysOf returns the primary keys of rows in their order.
+func primaryKeysOf(rows []*dbmodels.PrimaryKeyPageTuple) []any {
+	primaryKeys := make([]any, len(rows))
+	for i, row := range rows {
+		primaryKeys[i] = row.PrimaryKey
+	}
+	return primaryKeys
+}
+
+// openCountryAgeIndex opens a composite index over country ascending and age descending, holding the row
+// 10*country+age/10 under every country of de, fr and it, numbered 1 to 3, and every age of 20, 30 and 40.
+func openCountryAgeIndex(t *testing.T) *Tree {
+	t.Helper()
+	IndexDirectory = t.TempDir()
+	tree, err := NewComposite("collection", []IndexField{{Name: "country"}, {Name: "age", Descending: true}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+
+	for i, country := range []string{"de", "fr", "it"} {
+		for _, age := range []int{20, 30, 40} {
+			if err = tree.Put(10*(i+1)+age/10, CompositeKey{country, age}, &dbmodels.Page{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return tree
+}
+
+func TestCompositeRangeQueries(t *testing.T) {
+	tree := openCountryAgeIndex(t)
+
+	for _, c := range []struct {
+		name     string
+		keyRange KeyRange
+		want     []any
+	}{
+		{"every key", KeyRange{}, []any{14, 13, 12, 24, 23, 22, 34, 33, 32}},
+		{"prefix", Between(CompositeKey{"fr"}, CompositeKey{"fr"}), []any{24, 23, 22}},
+		{"exclusive prefixes", KeyRange{}.Above(CompositeKey{"de"}).Below(CompositeKey{"it"}), []any{24, 23, 22}},
+		{"exclusive prefix lower", KeyRange{}.Above(CompositeKey{"fr"}), []any{34, 33, 32}},
+		{"inclusive prefix upper", KeyRange{}.AtMost(CompositeKey{"fr"}), []any{14, 13, 12, 24, 23, 22}},
+		// The lower bound of a descending field is its largest value
+		{"descending field", Between(CompositeKey{"fr", 40}, CompositeKey{"fr", 30}), []any{24, 23}},
+		{"across prefixes", Between(CompositeKey{"de", 30}, CompositeKey{"fr", 30}), []any{13, 12, 24, 23}},
+		{"exclusive keys", KeyRange{}.Above(CompositeKey{"de", 30}).Below(CompositeKey{"fr", 30}), []any{12, 24}},
+	} {
+		rows, _, err := tree.RangeSorted(c.keyRange, 100, "")
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if got := primaryKeysOf(rows); !slices.Equal(got, c.want) {
+			t.Errorf("%s: RangeSorted returned %v, want %v", c.name, got, c.want)
+		}
+
+		reversed := slices.Clone(c.want)
+		slices.Reverse(reversed)
+		got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeReverseSorted(c.keyRange, limit, cursor)
+		}, 2)
+		if !slices.Equal(got, reversed) {
+			t.Errorf("%s: RangeReverseSorted returned %v, want %v", c.name, got, reversed)
+		}
+	}
+}
+
+func TestCompositeSeekAndInSorted(t *testing.T) {
+	tree := openCountryAgeIndex(t)
+
+	e, err := tree.Seek(CompositeKey{"fr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	key, _, err := e.Next()
+	if err != nil || !slices.Equal((*key).(CompositeKey), CompositeKey{"fr", 40}) {
+		t.Fatal("seek to a prefix found", key, err)
+	}
+
+	keys := []any{CompositeKey{"it", 20}, CompositeKey{"de", 30}, CompositeKey{"fr", 50}, CompositeKey{"de", 40}}
+	got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+		return tree.InSorted(keys, limit, cursor)
+	}, 1)
+	if want := []any{14, 13, 32}; !slices.Equal(got, want) {
+		t.Fatalf("InSorted returned %v, want %v", got, want)
+	}
+}
+
+func TestCompositeIndexRejectsOtherKeys(t *testing.T) {
+	tree := openCountryAgeIndex(t)
+
+	for _, key := range []any{"de", CompositeKey{"de"}, CompositeKey{"de", 20, 1}} {
+		if err := tree.Put(99, key, &dbmodels.Page{}); !errors.Is(err, ErrKeyType) {
+			t.Errorf("Put under %v: %v", key, err)
+		}
+	}
+	if _, err := NewComposite("collection", []IndexField{{Name: "country"}, {Name: "country"}}, Options{}); !errors.Is(err, ErrInvalidOptions) {
+		t.Error("fields named twice", err)
+	}
+	if _, err := NewComposite("collection", []IndexField{{Name: "country"}}, Options{Descending: true}); !errors.Is(err, ErrInvalidOptions) {
+		t.Error("descending composite index", err)
+	}
+}
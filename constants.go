@@ -1,6 +1,9 @@
 package bptree
 
 import (
+	"bptree/btree"
+	"crypto/sha256"
+	"encoding/base32"
 	"fmt"
 	"os"
 )
@@ -24,6 +27,33 @@ func IndexFile(collectionName string, fieldName string) string {
 	return IndexDirectory + "/" + collectionName + "-" + fieldName + ".idx.sieve"
 }
 
-func SubIndexFile(collectionName string, fieldName string, key any) string {
-	return fmt.Sprintf("%s/%s-%s-%v.idx.sieve", IndexDirectory, collectionName, fieldName, key)
+// CompositeIndexFile returns the index file of the composite index over fields, named after CompositeFieldName.
+func CompositeIndexFile(collectionName string, fields []IndexField) string {
+	return IndexFile(collectionName, CompositeFieldName(fields))
+}
+
+// Keys are named in lower case base32 so that file systems ignoring case keep them apart. Keys encoding to more
+// than maxSubIndexKeyLength bytes are named by the digest of their encoding to stay within file name limits.
+const maxSubIndexKeyLength = 96
+
+var subIndexKeyEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// SubIndexFile returns the sub index file holding the rows of key. The file is named after the type and value of
+// the key as encoded by btree.EncodeValues, so no two keys share a file.
+func SubIndexFile(collectionName string, fieldName string, key any) (string, error) {
+	encoded, err := btree.EncodeValues(key)
+	if err != nil {
+		return "", err
+	}
+	name := subIndexKeyEncoding.EncodeToString(encoded)
+	if len(encoded) > maxSubIndexKeyLength {
+		digest := sha256.Sum256(encoded)
+		name = "h-" + subIndexKeyEncoding.EncodeToString(digest[:])
+	}
+	return subIndexFileName(collectionName, fieldName, name), nil
+}
+
+// subIndexFileName returns the sub index file named name, which may be a glob pattern.
+func subIndexFileName(collectionName string, fieldName string, name string) string {
+	return fmt.Sprintf("%s/%s-%s-%s.idx.sieve", IndexDirectory, collectionName, fieldName, name)
 }
@@ -0,0 +1,171 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"bptree/dbmodels"
+	"bptree/utils"
+	"encoding/base64"
+	"fmt"
+)
+
+// Cursor marks the row a page of sorted rows ended with, so that the next page resumes right after it. Rows are
+// sorted by key in the order of the index and, within a key, by primary key, and the position is kept by value: rows
+// put or deleted between two pages neither shift the next page nor repeat rows. The empty Cursor starts from the
+// first row and a page ending with the last row returns the empty Cursor. A cursor is opaque, URL safe text and only
+// resumes the query it was returned by.
+type Cursor string
+
+// cursorPosition is the key and primary key of the row a Cursor resumes after.
+type cursorPosition struct {
+	key        any
+	primaryKey any
+}
+
+func newCursor(position cursorPosition) (Cursor, error) {
+	encoded, err := btree.EncodeValues(position.key, position.primaryKey)
+	if err != nil {
+		return "", err
+	}
+	return Cursor(base64.RawURLEncoding.EncodeToString(encoded)), nil
+}
+
+// position decodes the cursor, nil for the empty Cursor.
+func (cursor Cursor) position() (*cursorPosition, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	encoded, err := base64.RawURLEncoding.DecodeString(string(cursor))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	values, err := btree.DecodeValues(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if len(values) != 2 {
+		return nil, fmt.Errorf("%w: %d values", ErrInvalidCursor, len(values))
+	}
+	return &cursorPosition{key: values[0], primaryKey: values[1]}, nil
+}
+
+// pager collects a page of at most limit sorted rows, leaving out the rows up to the cursor it resumes from.
+type pager struct {
+	tree    *Tree
+	cursor  Cursor
+	after   *cursorPosition // Position of the cursor, nil on the first page
+	reverse bool            // Rows are walked from the last one
+	limit   int
+	count   int
+	last    cursorPosition // Last row of the page
+	full    bool           // A row beyond the page was met
+}
+
+func (tree *Tree) newPager(limit int, cursor Cursor, reverse bool) (*pager, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLimit, limit)
+	}
+	after, err := cursor.position()
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		// A cursor is client input, it may decode to any value the codec knows
+		if err = tree.checkRow(after.primaryKey, after.key); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+		}
+	}
+	return &pager{tree: tree, cursor: cursor, after: after, reverse: reverse, limit: limit}, nil
+}
+
+// resumeRange returns keyRange narrowed down to the keys from the key of the cursor on. A cursor whose key lies
+// outside keyRange was returned by another query and is rejected. Callers must hold the tree lock.
+func (pager *pager) resumeRange(keyRange KeyRange) (KeyRange, error) {
+	if pager.after == nil {
+		return keyRange, nil
+	}
+	if err := checkKeyRange(keyRange); err != nil {
+		return KeyRange{}, err
+	}
+	index := pager.tree.index
+	if !afterLower(index, keyRange, pager.after.key) || !beforeUpper(index, keyRange, pager.after.key) {
+		return KeyRange{}, fmt.Errorf("%w: key %v is outside the range", ErrInvalidCursor, pager.after.key)
+	}
+	if pager.reverse {
+		return keyRange.AtMost(pager.after.key), nil
+	}
+	return keyRange.AtLeast(pager.after.key), nil
+}
+
+// compare orders the row of primaryKey under key against the cursor in the order rows are walked.
+func (pager *pager) compare(key any, primaryKey any) int {
+	result := pager.tree.index.Compare(key, pager.after.key)
+	if result == 0 {
+		result = utils.Compare(primaryKey, pager.after.primaryKey)
+	}
+	if pager.reverse {
+		return -result
+	}
+	return result
+}
+
+// skip tells whether the row of primaryKey under key was on a previous page.
+func (pager *pager) skip(key any, primaryKey any) bool {
+	return pager.after != nil && pager.compare(key, primaryKey) <= 0
+}
+
+// add records the row of primaryKey under key as the last of the page, returning false once the page is full.
+func (pager *pager) add(key any, primaryKey any) bool {
+	if pager.count >= pager.limit {
+		pager.full = true
+		return false
+	}
+	pager.count++
+	pager.last = cursorPosition{key: key, primaryKey: primaryKey}
+	return true
+}
+
+// rowsOf returns the rows of key left for the page in the order they are walked, after the cursor when it is within
+// key, and at most one row beyond the room left so that add tells the page is full.
+func (pager *pager) rowsOf(key any, resultSet *ResultSet) ([]dbmodels.Row, error) {
+	var enumerator *RowEnumerator
+	var err error
+	switch {
+	case pager.after != nil && pager.tree.index.Compare(key, pager.after.key) == 0:
+		enumerator, err = resultSet.Seek(pager.after.primaryKey)
+	case pager.reverse:
+		enumerator, err = resultSet.SeekLast()
+	default:
+		enumerator, err = resultSet.SeekFirst()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer enumerator.Close()
+
+	hasNext, next := enumerator.HasNext, enumerator.Next
+	if pager.reverse {
+		hasNext, next = enumerator.HasPrevious, enumerator.Previous
+	}
+	var rows []dbmodels.Row
+	for len(rows) <= pager.limit-pager.count && hasNext() {
+		primaryKey, page, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if !pager.skip(key, primaryKey) {
+			rows = append(rows, dbmodels.Row{PrimaryKey: primaryKey, Page: page})
+		}
+	}
+	return rows, nil
+}
+
+// next returns the cursor resuming after the page, empty when no row is left.
+func (pager *pager) next() (Cursor, error) {
+	switch {
+	case !pager.full:
+		return "", nil
+	case pager.count == 0:
+		return pager.cursor, nil
+	}
+	return newCursor(pager.last)
+}
@@ -0,0 +1,192 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"errors"
+	"slices"
+	"testing"
+)
+
+type sortedQuery func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error)
+
+// pageThrough returns the primary keys of every page of query, fetched limit rows at a time.
+func pageThrough(t *testing.T, query sortedQuery, limit int) []any {
+	t.Helper()
+	var primaryKeys []any
+	var cursor Cursor
+	for pages := 0; ; pages++ {
+		rows, next, err := query(limit, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) > limit || pages > 1000 {
+			t.Fatalf("page %d holds %d rows", pages, len(rows))
+		}
+		for _, row := range rows {
+			primaryKeys = append(primaryKeys, row.PrimaryKey)
+		}
+		if next == "" {
+			return primaryKeys
+		}
+		cursor = next
+	}
+}
+
+// putCursorRows puts the rows 100*key+i of key for i below key%6, so that keys hold no row, inline rows or a sub
+// tree.
+func putCursorRows(t *testing.T, tree *Tree) {
+	t.Helper()
+	for key := 0; key < 10; key++ {
+		for i := 0; i < key%6; i++ {
+			primaryKey := 100*key + i
+			if err := tree.Put(primaryKey, key, &dbmodels.Page{DataOffset: int64(primaryKey)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSortedQueriesPageThroughRows(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 3})
+	putCursorRows(t, tree)
+
+	var want []any
+	for key := 2; key <= 8; key++ {
+		for i := 0; i < key%6; i++ {
+			want = append(want, 100*key+i)
+		}
+	}
+	reversed := slices.Clone(want)
+	slices.Reverse(reversed)
+
+	for _, limit := range []int{1, 2, 3, 5, 100} {
+		got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeSorted(Between(2, 8), limit, cursor)
+		}, limit)
+		if !slices.Equal(got, want) {
+			t.Fatalf("limit %d: RangeSorted returned %v, want %v", limit, got, want)
+		}
+
+		got = pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeReverseSorted(Between(2, 8), limit, cursor)
+		}, limit)
+		if !slices.Equal(got, reversed) {
+			t.Fatalf("limit %d: RangeReverseSorted returned %v, want %v", limit, got, reversed)
+		}
+
+		got = pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.InSorted([]any{8, 2, 5, 3, 4, 6, 7, 42}, limit, cursor)
+		}, limit)
+		if !slices.Equal(got, want) {
+			t.Fatalf("limit %d: InSorted returned %v, want %v", limit, got, want)
+		}
+	}
+}
+
+func TestCursorKeepsPositionAcrossWrites(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 3})
+	putCursorRows(t, tree)
+
+	rows, cursor, err := tree.RangeSorted(KeyRange{}, 3, "")
+	if err != nil || len(rows) != 3 || cursor == "" {
+		t.Fatal(len(rows), cursor, err)
+	}
+	last := rows[len(rows)-1].PrimaryKey
+	if last != 201 {
+		t.Fatal("first page ended with", last)
+	}
+
+	// Rows deleted or put before the cursor neither shift the next page nor come back
+	if _, err = tree.Delete(last, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Put(150, 1, &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Put(200, 2, &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Put(250, 2, &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rest := pageThrough(t, func(limit int, next Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+		if next == "" {
+			next = cursor
+		}
+		return tree.RangeSorted(KeyRange{}, limit, next)
+	}, 3)
+	want := []any{250, 300, 301, 302, 400, 401, 402, 403, 500, 501, 502, 503, 504, 700, 800, 801, 900, 901, 902}
+	if !slices.Equal(rest, want) {
+		t.Fatalf("pages after the cursor returned %v, want %v", rest, want)
+	}
+}
+
+func TestSortedQueriesRejectInvalidArguments(t *testing.T) {
+	tree := openTestTree(t, Options{})
+	putCursorRows(t, tree)
+
+	for _, limit := range []int{0, -1} {
+		if _, _, err := tree.RangeSorted(KeyRange{}, limit, ""); !errors.Is(err, ErrInvalidLimit) {
+			t.Fatal("RangeSorted", limit, err)
+		}
+		if _, _, err := tree.InSorted([]any{1}, limit, ""); !errors.Is(err, ErrInvalidLimit) {
+			t.Fatal("InSorted", limit, err)
+		}
+		if _, _, err := tree.All(limit, ""); !errors.Is(err, ErrInvalidLimit) {
+			t.Fatal("All", limit, err)
+		}
+	}
+	cursors := []Cursor{"not a cursor!", "AAAA"}
+	// Cursors decoding to values that are no keys or primary keys of the index
+	for _, position := range []cursorPosition{
+		{key: map[any]*dbmodels.Page{}, primaryKey: 1},
+		{key: 1, primaryKey: dbmodels.Rows{}},
+	} {
+		cursor, err := newCursor(position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursors = append(cursors, cursor)
+	}
+	for _, cursor := range cursors {
+		if _, _, err := tree.RangeSorted(KeyRange{}, 10, cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatal(cursor, err)
+		}
+		if _, _, err := tree.InSorted([]any{1, 2}, 10, cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatal(cursor, err)
+		}
+	}
+}
+
+func TestCursorOutsideRangeIsRejected(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 3})
+	putCursorRows(t, tree)
+
+	_, cursor, err := tree.RangeSorted(Between(2, 8), 3, "")
+	if err != nil || cursor == "" {
+		t.Fatal(cursor, err)
+	}
+	// The page ended within key 3
+	for _, keyRange := range []KeyRange{Between(5, 8), Between(0, 2), KeyRange{}.Above(3), KeyRange{}.Below(3)} {
+		if _, _, err = tree.RangeSorted(keyRange, 3, cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatal(keyRange, err)
+		}
+	}
+	rows, _, err := tree.RangeSorted(Between(3, 4), 3, cursor)
+	if err != nil || len(rows) != 3 || rows[0].PrimaryKey != 301 || rows[2].PrimaryKey != 400 {
+		t.Fatal(rows, err)
+	}
+
+	_, cursor, err = tree.RangeReverseSorted(Between(2, 8), 3, "")
+	if err != nil || cursor == "" {
+		t.Fatal(cursor, err)
+	}
+	// The page ended with key 7
+	if _, _, err = tree.RangeReverseSorted(Between(2, 5), 3, cursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatal(err)
+	}
+	if _, _, err = tree.RangeAndRelevantKeysSorted(Between(2, 5), map[any]float64{700: 1}, 3, cursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatal(err)
+	}
+}
@@ -18,3 +18,9 @@ type PageAndData struct {
 func NewPage(offset int64) *Page {
 	return &Page{DataOffset: offset}
 }
+
+// TypedSortParamLocation is a SortParamLocation whose sort param is a key of type K.
+type TypedSortParamLocation[K any] struct {
+	SortParam K
+	Locations []*Page
+}
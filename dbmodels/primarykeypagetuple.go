@@ -5,3 +5,10 @@ type PrimaryKeyPageTuple struct {
 	Key        any
 	Page       *Page
 }
+
+// TypedPrimaryKeyPageTuple is a PrimaryKeyPageTuple with a key of type K and a primary key of type PK.
+type TypedPrimaryKeyPageTuple[K, PK any] struct {
+	PrimaryKey PK
+	Key        K
+	Page       *Page
+}
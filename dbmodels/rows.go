@@ -0,0 +1,74 @@
+package dbmodels
+
+import (
+	"bptree/utils"
+	"slices"
+)
+
+// Row is the page of a primary key stored under a key.
+type Row struct {
+	PrimaryKey any
+	Page       *Page
+}
+
+// Rows holds the rows of a key sorted by primary key with utils.Compare, the order of the sub trees holding the rows
+// of larger keys. Rows shared with the page pool are never changed, With and Without return a changed copy.
+type Rows []Row
+
+// RowsOf returns the rows of pages sorted by primary key.
+func RowsOf(pages map[any]*Page) Rows {
+	rows := make(Rows, 0, len(pages))
+	for primaryKey, page := range pages {
+		rows = append(rows, Row{PrimaryKey: primaryKey, Page: page})
+	}
+	slices.SortFunc(rows, func(a, b Row) int {
+		return utils.Compare(a.PrimaryKey, b.PrimaryKey)
+	})
+	return rows
+}
+
+// Search returns the position of primaryKey in rows, or the position it would be inserted at, and whether it is
+// present.
+func (rows Rows) Search(primaryKey any) (int, bool) {
+	return slices.BinarySearchFunc(rows, primaryKey, func(row Row, primaryKey any) int {
+		return utils.Compare(row.PrimaryKey, primaryKey)
+	})
+}
+
+// Get returns the page of primaryKey.
+func (rows Rows) Get(primaryKey any) (*Page, bool) {
+	i, found := rows.Search(primaryKey)
+	if !found {
+		return nil, false
+	}
+	return rows[i].Page, true
+}
+
+// With returns a copy of rows holding page for primaryKey.
+func (rows Rows) With(primaryKey any, page *Page) Rows {
+	i, found := rows.Search(primaryKey)
+	updated := slices.Clone(rows)
+	if found {
+		updated[i].Page = page
+		return updated
+	}
+	return slices.Insert(updated, i, Row{PrimaryKey: primaryKey, Page: page})
+}
+
+// Without returns a copy of rows without primaryKey.
+func (rows Rows) Without(primaryKey any) Rows {
+	i, found := rows.Search(primaryKey)
+	if !found {
+		return rows
+	}
+	return slices.Delete(slices.Clone(rows), i, i+1)
+}
+
+// Map returns the pages of rows by primary key.
+func (rows Rows) Map() map[any]*Page {
+	pages := make(map[any]*Page, len(rows))
+	for _, row := range rows {
+		pages[row.PrimaryKey] = row.Page
+	}
+	return pages
+}
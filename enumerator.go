@@ -2,40 +2,59 @@ package bptree
 
 import (
 	"bptree/btree"
-	"log"
-	"os"
+	"cmp"
 )
 
+// Enumerator walks the keys of an index as they were when it was created, whatever is written to the index in the
+// meantime, and the ResultSets it returns read the rows of that moment until it is closed, also of sub trees removed
+// since. Writes keep copies of the pages they overwrite in the page pool for as long as such an enumerator is open,
+// so an enumerator should be closed once done.
 type Enumerator struct {
 	btreeEnumerator *btree.Enumerator[any, any]
-	file            *os.File
+	handle          *fileHandle
+	tree            *Tree
+	index           *btree.BTree[any, any] // Index as of snapshot, compares keys without the tree lock
+	keyRange        *KeyRange              // Range whose upper bound ends the walk forwards, nil when it goes on to the last key
+	snapshot        *btree.Snapshot
 }
 
-func (enumerator *Enumerator) Next() (*any, *ResultSet) {
+func (enumerator *Enumerator) Next() (*any, *ResultSet, error) {
 	if !enumerator.HasNext() {
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	//timer := time.Now()
-	key, value := enumerator.btreeEnumerator.Next(enumerator.file)
-	if key == nil || value == nil {
-		log.Printf("nil")
+	key, value, err := enumerator.btreeEnumerator.Next(enumerator.handle.file)
+	if err != nil {
+		return nil, nil, err
 	}
-	//log.Printf("Computing next, time=%s", time.Since(timer))
-	return key, &ResultSet{treeValue: value}
+	return key, &ResultSet{treeValue: value, tree: enumerator.tree, snapshot: enumerator.snapshot}, nil
 }
 
-func (enumerator *Enumerator) Previous() (*any, *ResultSet) {
+func (enumerator *Enumerator) Previous() (*any, *ResultSet, error) {
 	if !enumerator.HasPrevious() {
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	key, value := enumerator.btreeEnumerator.Previous(enumerator.file)
-	return key, &ResultSet{treeValue: value}
+	key, value, err := enumerator.btreeEnumerator.Previous(enumerator.handle.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, &ResultSet{treeValue: value, tree: enumerator.tree, snapshot: enumerator.snapshot}, nil
 }
 
 func (enumerator *Enumerator) HasNext() bool {
-	return enumerator.btreeEnumerator.HasNext()
+	if !enumerator.btreeEnumerator.HasNext() {
+		return false
+	}
+	if enumerator.keyRange == nil || enumerator.keyRange.Upper == nil {
+		return true
+	}
+	key, err := enumerator.btreeEnumerator.Peek(enumerator.handle.file)
+	if err != nil {
+		// Next reports the error
+		return true
+	}
+	return beforeUpper(enumerator.index, *enumerator.keyRange, *key)
 }
 
 func (enumerator *Enumerator) HasPrevious() bool {
@@ -43,6 +62,55 @@ func (enumerator *Enumerator) HasPrevious() bool {
 }
 
 func (enumerator *Enumerator) Close() {
+	if enumerator.handle == nil {
+		return
+	}
 	enumerator.btreeEnumerator.Close()
-	enumerator.file.Close()
+	enumerator.handle.release()
+	enumerator.handle = nil
+	enumerator.snapshot.Release()
+	enumerator.tree.releaseRetired()
+}
+
+// TypedEnumerator walks the keys of a TypedTree like Enumerator.
+type TypedEnumerator[K cmp.Ordered, PK comparable] struct {
+	enumerator *Enumerator
+}
+
+func typedEnumerator[K cmp.Ordered, PK comparable](enumerator *Enumerator, err error) (*TypedEnumerator[K, PK], error) {
+	if err != nil {
+		return nil, err
+	}
+	return &TypedEnumerator[K, PK]{enumerator: enumerator}, nil
+}
+
+func (enumerator *TypedEnumerator[K, PK]) Next() (*K, *TypedResultSet[PK], error) {
+	return typedEntry[K, PK](enumerator.enumerator.Next())
+}
+
+func (enumerator *TypedEnumerator[K, PK]) Previous() (*K, *TypedResultSet[PK], error) {
+	return typedEntry[K, PK](enumerator.enumerator.Previous())
+}
+
+func typedEntry[K cmp.Ordered, PK comparable](key *any, rows *ResultSet, err error) (*K, *TypedResultSet[PK], error) {
+	if err != nil || key == nil {
+		return nil, nil, err
+	}
+	typedKey, err := typedValue[K](*key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &typedKey, &TypedResultSet[PK]{rows: rows}, nil
+}
+
+func (enumerator *TypedEnumerator[K, PK]) HasNext() bool {
+	return enumerator.enumerator.HasNext()
+}
+
+func (enumerator *TypedEnumerator[K, PK]) HasPrevious() bool {
+	return enumerator.enumerator.HasPrevious()
+}
+
+func (enumerator *TypedEnumerator[K, PK]) Close() {
+	enumerator.enumerator.Close()
 }
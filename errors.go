@@ -0,0 +1,66 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Errors returned by a tree wrap these with details, they are told apart with errors.Is, e.g. a missing index file
+// from a corrupt one.
+var (
+	// ErrIndexNotFound is returned when the index file or a sub index file of a tree does not exist.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrIndexNotEmpty is returned by BulkLoad on an index that already holds rows.
+	ErrIndexNotEmpty = errors.New("index is not empty")
+
+	// ErrKeyType is returned by a TypedTree reading a key or primary key of another type than its own, and by a
+	// composite index given a key that does not hold a value per field.
+	ErrKeyType = errors.New("index holds a key of another type")
+
+	// ErrUnsupportedKey is returned by every method of a tree given a key, a range bound or a primary key of a type
+	// the index cannot order, see utils.Compare.
+	ErrUnsupportedKey = errors.New("unsupported key type")
+
+	// ErrDuplicateKey is returned by Put, Update and BulkLoad on a unique index when the key already holds the row of
+	// another primary key.
+	ErrDuplicateKey = errors.New("key already holds another primary key")
+
+	// ErrInvalidCursor is returned by the sorted queries given a Cursor they did not return.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidLimit is returned by the sorted queries given a limit below 1, such a page could never move on. A limit
+	// of 0 used to return an empty page along with the cursor it was given.
+	ErrInvalidLimit = errors.New("limit must be positive")
+
+	// ErrClosed is returned by every method of a tree after Close.
+	ErrClosed = errors.New("index is closed")
+
+	// ErrCorrupt is matched by every error reporting a block of an index file that cannot be decoded, the error
+	// itself is a btree.ErrCorruptPage locating the block.
+	ErrCorrupt = btree.ErrCorrupt
+
+	// ErrUnknownCodec is returned when an index file records a page codec that is not registered.
+	ErrUnknownCodec = btree.ErrUnknownCodec
+
+	// ErrUnknownComparator is returned when an index is created with or its file records a comparator that is not
+	// registered.
+	ErrUnknownComparator = btree.ErrUnknownComparator
+
+	// ErrInvalidOptions is returned by New given options an index cannot be created with.
+	ErrInvalidOptions = btree.ErrInvalidOptions
+
+	// ErrOptionsMismatch is returned by New when the index was created with other options.
+	ErrOptionsMismatch = btree.ErrOptionsMismatch
+)
+
+// openIndexFile opens an index or sub index file, reporting ErrIndexNotFound when it is missing.
+func openIndexFile(indexName string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(indexName, flag, os.ModePerm)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrIndexNotFound, err)
+	}
+	return file, err
+}
@@ -0,0 +1,129 @@
+package bptree
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// fileHandle is an open index file shared by a tree and the enumerators reading it. The file is closed once
+// every holder has released it, so an enumerator keeps reading the file it started on even if the tree swaps
+// the file underneath it.
+type fileHandle struct {
+	file *os.File
+	refs atomic.Int32
+}
+
+func newFileHandle(file *os.File) *fileHandle {
+	handle := &fileHandle{file: file}
+	handle.refs.Store(1)
+	return handle
+}
+
+func (handle *fileHandle) acquire() *fileHandle {
+	handle.refs.Add(1)
+	return handle
+}
+
+func (handle *fileHandle) release() error {
+	if handle.refs.Add(-1) == 0 {
+		return handle.file.Close()
+	}
+	return nil
+}
+
+// handleCache keeps up to capacity sub index files open, closing the least recently used one to make room. flush is
+// called on a file before it is dropped from the cache.
+type handleCache struct {
+	lock     sync.Mutex
+	capacity int
+	flush    func(*os.File) error
+	closed   bool
+	order    *list.List // Front is the most recently used handle
+	handles  map[string]*list.Element
+}
+
+type cachedHandle struct {
+	indexName string
+	handle    *fileHandle
+}
+
+func newHandleCache(capacity int, flush func(*os.File) error) *handleCache {
+	return &handleCache{
+		capacity: capacity,
+		flush:    flush,
+		order:    list.New(),
+		handles:  map[string]*list.Element{},
+	}
+}
+
+// open returns a handle on indexName which the caller must release. Files not cached yet are opened with flag.
+func (cache *handleCache) open(indexName string, flag int) (*fileHandle, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.closed {
+		return nil, ErrClosed
+	}
+
+	if element, ok := cache.handles[indexName]; ok {
+		cache.order.MoveToFront(element)
+		return element.Value.(*cachedHandle).handle.acquire(), nil
+	}
+
+	file, err := openIndexFile(indexName, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	handle := newFileHandle(file)
+	cache.handles[indexName] = cache.order.PushFront(&cachedHandle{indexName: indexName, handle: handle})
+	for cache.order.Len() > cache.capacity {
+		if err := cache.removeElement(cache.order.Back()); err != nil {
+			return nil, err
+		}
+	}
+	return handle.acquire(), nil
+}
+
+// evict drops the handle on indexName, e.g. before the file is removed or replaced.
+func (cache *handleCache) evict(indexName string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if element, ok := cache.handles[indexName]; ok {
+		cache.removeElement(element)
+	}
+}
+
+// evictAll drops every cached handle.
+func (cache *handleCache) evictAll() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for cache.order.Len() > 0 {
+		cache.removeElement(cache.order.Back())
+	}
+}
+
+// close calls checkpoint on every cached file, releases them and refuses to open any more.
+func (cache *handleCache) close(checkpoint func(*os.File) error) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	var err error
+	for cache.order.Len() > 0 {
+		element := cache.order.Back()
+		err = errors.Join(err, checkpoint(element.Value.(*cachedHandle).handle.file), cache.removeElement(element))
+	}
+	cache.closed = true
+	return err
+}
+
+func (cache *handleCache) removeElement(element *list.Element) error {
+	cached := cache.order.Remove(element).(*cachedHandle)
+	delete(cache.handles, cached.indexName)
+	return errors.Join(cache.flush(cached.handle.file), cached.handle.release())
+}
@@ -1,6 +1,6 @@
 module bptree
 
-go 1.22.5
+go 1.23
 
 require github.com/dgraph-io/ristretto v0.2.0
 
@@ -0,0 +1,165 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"cmp"
+)
+
+// Bound is a side of a KeyRange.
+type Bound struct {
+	Key       any
+	Exclusive bool // Leave out the keys equal to Key
+}
+
+// KeyRange selects the keys of a range query, from Lower to Upper in the order of the index, so the lower bound of a
+// descending index is its largest key. A nil bound leaves its side of the range open, the zero KeyRange selects
+// every key. Each bound is inclusive or exclusive, e.g. KeyRange{}.Above(x).AtMost(y) selects x < key <= y. A bound
+// holding the leading fields of a composite index includes or excludes every key starting with them.
+type KeyRange struct {
+	Lower *Bound
+	Upper *Bound
+}
+
+// Between selects the keys from lower to upper, both included.
+func Between(lower any, upper any) KeyRange {
+	return KeyRange{Lower: &Bound{Key: lower}, Upper: &Bound{Key: upper}}
+}
+
+// Above returns keyRange limited to the keys after key, i.e. key < x.
+func (keyRange KeyRange) Above(key any) KeyRange {
+	keyRange.Lower = &Bound{Key: key, Exclusive: true}
+	return keyRange
+}
+
+// AtLeast returns keyRange limited to the keys from key on, i.e. key <= x.
+func (keyRange KeyRange) AtLeast(key any) KeyRange {
+	keyRange.Lower = &Bound{Key: key}
+	return keyRange
+}
+
+// Below returns keyRange limited to the keys before key, i.e. x < key.
+func (keyRange KeyRange) Below(key any) KeyRange {
+	keyRange.Upper = &Bound{Key: key, Exclusive: true}
+	return keyRange
+}
+
+// AtMost returns keyRange limited to the keys up to key, i.e. x <= key.
+func (keyRange KeyRange) AtMost(key any) KeyRange {
+	keyRange.Upper = &Bound{Key: key}
+	return keyRange
+}
+
+// TypedKeyRange selects the keys of a range query of a TypedTree like KeyRange, its zero value selecting every key.
+type TypedKeyRange[K cmp.Ordered] struct {
+	keyRange KeyRange
+}
+
+// TypedBetween selects the keys from lower to upper, both included.
+func TypedBetween[K cmp.Ordered](lower K, upper K) TypedKeyRange[K] {
+	return TypedKeyRange[K]{keyRange: Between(lower, upper)}
+}
+
+func (keyRange TypedKeyRange[K]) Above(key K) TypedKeyRange[K] {
+	return TypedKeyRange[K]{keyRange: keyRange.keyRange.Above(key)}
+}
+
+func (keyRange TypedKeyRange[K]) AtLeast(key K) TypedKeyRange[K] {
+	return TypedKeyRange[K]{keyRange: keyRange.keyRange.AtLeast(key)}
+}
+
+func (keyRange TypedKeyRange[K]) Below(key K) TypedKeyRange[K] {
+	return TypedKeyRange[K]{keyRange: keyRange.keyRange.Below(key)}
+}
+
+func (keyRange TypedKeyRange[K]) AtMost(key K) TypedKeyRange[K] {
+	return TypedKeyRange[K]{keyRange: keyRange.keyRange.AtMost(key)}
+}
+
+// Untyped returns the KeyRange selecting the same keys.
+func (keyRange TypedKeyRange[K]) Untyped() KeyRange {
+	return keyRange.keyRange
+}
+
+// checkKeyRange returns ErrUnsupportedKey when a bound of keyRange holds a key the index cannot order.
+func checkKeyRange(keyRange KeyRange) error {
+	for _, bound := range []*Bound{keyRange.Lower, keyRange.Upper} {
+		if bound == nil {
+			continue
+		}
+		if err := checkQueryKey(bound.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// afterLower tells whether key is within the lower bound of keyRange in the order of index.
+func afterLower(index *btree.BTree[any, any], keyRange KeyRange, key any) bool {
+	switch lower := keyRange.Lower; {
+	case lower == nil:
+		return true
+	case lower.Exclusive:
+		return index.ComparePrefix(key, lower.Key) > 0
+	default:
+		return index.Compare(key, lower.Key) >= 0
+	}
+}
+
+// beforeUpper tells whether key is within the upper bound of keyRange in the order of index.
+func beforeUpper(index *btree.BTree[any, any], keyRange KeyRange, key any) bool {
+	switch upper := keyRange.Upper; {
+	case upper == nil:
+		return true
+	case upper.Exclusive:
+		return index.Compare(key, upper.Key) < 0
+	default:
+		return index.ComparePrefix(key, upper.Key) <= 0
+	}
+}
+
+// SeekRange returns an enumerator over the keys of keyRange. Next walks them from the lower bound and HasNext turns
+// false past the upper bound.
+func (tree *Tree) SeekRange(keyRange KeyRange) (*Enumerator, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return tree.seekRange(keyRange)
+}
+
+func (tree *Tree) seekRange(keyRange KeyRange) (*Enumerator, error) {
+	if err := checkKeyRange(keyRange); err != nil {
+		return nil, err
+	}
+
+	var enumerator *Enumerator
+	var err error
+	switch lower := keyRange.Lower; {
+	case lower == nil:
+		enumerator, err = tree.seekFirst()
+	case lower.Exclusive:
+		enumerator, err = tree.seekAfter(lower.Key)
+	default:
+		enumerator, err = tree.seek(lower.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	enumerator.keyRange = &keyRange
+	return enumerator, nil
+}
+
+// seekRangeEnd returns an enumerator walking backwards from the upper bound of keyRange, it is left to the caller to
+// stop at the lower bound.
+func (tree *Tree) seekRangeEnd(keyRange KeyRange) (*Enumerator, error) {
+	if err := checkKeyRange(keyRange); err != nil {
+		return nil, err
+	}
+	switch upper := keyRange.Upper; {
+	case upper == nil:
+		return tree.seekLast()
+	case upper.Exclusive:
+		return tree.seek(upper.Key)
+	default:
+		return tree.seekAfter(upper.Key)
+	}
+}
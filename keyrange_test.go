@@ -0,0 +1,188 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// rangeRow is a row of the index opened by openRangeTree.
+type rangeRow struct {
+	key        int
+	primaryKey int
+}
+
+// openRangeTree opens an index of the even keys from 0 to 18 over several pages, key k holding the rows 10*k+i for i
+// up to k%3, inline or in a sub tree. The rows are returned ordered by key and primary key.
+func openRangeTree(t *testing.T) (*Tree, []rangeRow) {
+	t.Helper()
+	tree := openTestTree(t, Options{Order: 3, SubTreeThreshold: 3})
+	var rows []rangeRow
+	for key := 0; key <= 18; key += 2 {
+		for i := 0; i <= key%3; i++ {
+			row := rangeRow{key: key, primaryKey: 10*key + i}
+			if err := tree.Put(row.primaryKey, row.key, &dbmodels.Page{DataOffset: int64(row.primaryKey)}); err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return tree, rows
+}
+
+// rangeCases returns every range of open bounds and of inclusive and exclusive bounds at the first, last, an inner
+// and a missing key of openRangeTree and beyond its keys.
+func rangeCases() []KeyRange {
+	bounds := []*Bound{nil}
+	for _, key := range []int{-1, 0, 5, 6, 18, 19} {
+		bounds = append(bounds, &Bound{Key: key}, &Bound{Key: key, Exclusive: true})
+	}
+	var keyRanges []KeyRange
+	for _, lower := range bounds {
+		for _, upper := range bounds {
+			keyRanges = append(keyRanges, KeyRange{Lower: lower, Upper: upper})
+		}
+	}
+	return keyRanges
+}
+
+// describe formats keyRange like an interval.
+func describe(keyRange KeyRange) string {
+	lower, upper := "(-∞", "+∞)"
+	if bound := keyRange.Lower; bound != nil {
+		lower = fmt.Sprintf("[%v", bound.Key)
+		if bound.Exclusive {
+			lower = fmt.Sprintf("(%v", bound.Key)
+		}
+	}
+	if bound := keyRange.Upper; bound != nil {
+		upper = fmt.Sprintf("%v]", bound.Key)
+		if bound.Exclusive {
+			upper = fmt.Sprintf("%v)", bound.Key)
+		}
+	}
+	return lower + ", " + upper
+}
+
+// rowsWithin returns the primary keys of the rows of keyRange in order.
+func rowsWithin(rows []rangeRow, keyRange KeyRange) []any {
+	var primaryKeys []any
+	for _, row := range rows {
+		if lower := keyRange.Lower; lower != nil && (row.key < lower.Key.(int) || lower.Exclusive && row.key == lower.Key.(int)) {
+			continue
+		}
+		if upper := keyRange.Upper; upper != nil && (row.key > upper.Key.(int) || upper.Exclusive && row.key == upper.Key.(int)) {
+			continue
+		}
+		primaryKeys = append(primaryKeys, row.primaryKey)
+	}
+	return primaryKeys
+}
+
+func TestReverseQueriesHonourBounds(t *testing.T) {
+	tree, rows := openRangeTree(t)
+
+	for _, keyRange := range rangeCases() {
+		want := rowsWithin(rows, keyRange)
+		slices.Reverse(want)
+		for _, limit := range []int{1, 4, 100} {
+			got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+				return tree.RangeReverseSorted(keyRange, limit, cursor)
+			}, limit)
+			if !slices.Equal(got, want) {
+				t.Fatalf("%s, limit %d: RangeReverseSorted returned %v, want %v", describe(keyRange), limit, got, want)
+			}
+		}
+	}
+
+	want := rowsWithin(rows, KeyRange{})
+	slices.Reverse(want)
+	for _, limit := range []int{1, 4, 100} {
+		var got []any
+		var cursor Cursor
+		for {
+			locations, next, err := tree.AllReverse(limit, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, location := range locations {
+				for _, page := range location.Locations {
+					got = append(got, int(page.DataOffset))
+				}
+			}
+			if cursor = next; cursor == "" {
+				break
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("limit %d: AllReverse returned %v, want %v", limit, got, want)
+		}
+	}
+}
+
+func TestKeyRangeBoundsAreExact(t *testing.T) {
+	tree, rows := openRangeTree(t)
+	relevantKeys := map[any]float64{}
+	for _, row := range rows {
+		if row.primaryKey%2 == 0 {
+			relevantKeys[row.primaryKey] = 1
+		}
+	}
+
+	for _, keyRange := range rangeCases() {
+		name := describe(keyRange)
+		want := rowsWithin(rows, keyRange)
+
+		got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeSorted(keyRange, limit, cursor)
+		}, 3)
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s: RangeSorted returned %v, want %v", name, got, want)
+		}
+
+		pages, err := tree.Range(keyRange)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		got = slices.SortedFunc(maps.Keys(pages), func(a, b any) int { return a.(int) - b.(int) })
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s: Range returned %v, want %v", name, got, want)
+		}
+
+		relevant := slices.DeleteFunc(slices.Clone(want), func(primaryKey any) bool { return primaryKey.(int)%2 != 0 })
+		got = pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeAndRelevantKeysSorted(keyRange, relevantKeys, limit, cursor)
+		}, 2)
+		if !slices.Equal(got, relevant) {
+			t.Fatalf("%s: RangeAndRelevantKeysSorted returned %v, want %v", name, got, relevant)
+		}
+		if pages, err = tree.RangeAndRelevantKeys(keyRange, relevantKeys); err != nil || len(pages) != len(relevant) {
+			t.Fatalf("%s: RangeAndRelevantKeys returned %d rows, want %d: %v", name, len(pages), len(relevant), err)
+		}
+
+		// The keys an enumerator walks
+		var keys, wantKeys []int
+		e, err := tree.SeekRange(keyRange)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		for e.HasNext() {
+			key, _, err := e.Next()
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			keys = append(keys, (*key).(int))
+		}
+		e.Close()
+		for _, primaryKey := range want {
+			if key := primaryKey.(int) / 10; !slices.Contains(wantKeys, key) {
+				wantKeys = append(wantKeys, key)
+			}
+		}
+		if !slices.Equal(keys, wantKeys) {
+			t.Fatalf("%s: SeekRange walked %v, want %v", name, keys, wantKeys)
+		}
+	}
+}
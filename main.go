@@ -8,7 +8,11 @@ import (
 
 func main() {
 	// Create a new Tree
-	tree := New("test_collection", "test_field")
+	tree, err := New("test_collection", "test_field", Options{})
+	if err != nil {
+		panic(err)
+	}
+	defer tree.Close()
 
 	// Open a file to store the Tree data
 	file, err := os.OpenFile("btree_data.dat", os.O_RDWR|os.O_CREATE, 0755)
@@ -20,12 +24,17 @@ func main() {
 	// Insert some data into the Tree
 	for i := 1; i <= 10; i++ {
 		page := &dbmodels.Page{DataOffset: int64(i), FileOffset: uint8(i)}
-		tree.Put(i, i, page)
+		if err := tree.Put(i, i, page); err != nil {
+			panic(err)
+		}
 	}
 
 	// Search for a key in the Tree
 	keyToSearch := 5
-	pageMap, found := tree.Get(keyToSearch)
+	pageMap, found, err := tree.Get(keyToSearch)
+	if err != nil {
+		panic(err)
+	}
 	if found {
 		fmt.Printf("Found key %d: %+v\n", keyToSearch, pageMap)
 	} else {
@@ -33,9 +42,16 @@ func main() {
 	}
 
 	// Iterate over the Tree
-	enumerator := tree.SeekFirst()
+	enumerator, err := tree.SeekFirst()
+	if err != nil {
+		panic(err)
+	}
+	defer enumerator.Close()
 	for enumerator.HasNext() {
-		k, v := enumerator.Next()
+		k, v, err := enumerator.Next()
+		if err != nil {
+			panic(err)
+		}
 		fmt.Printf("Key: %v, Value: %+v\n", k, v)
 	}
 }
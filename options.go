@@ -0,0 +1,155 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Attributes of the main index recording the settings of its sub trees
+const (
+	subTreeOrderAttribute     = "subTreeOrder"
+	subTreeThresholdAttribute = "subTreeThreshold"
+	uniqueAttribute           = "unique"
+)
+
+// Options choose the layout of an index when it is created, zero fields take their default. They are recorded in
+// the index file and an existing index is always opened with the options it was created with, New returns
+// ErrOptionsMismatch when a non zero field differs from them.
+type Options struct {
+	Order            int // Order of the main index, BTreeOrder by default, e.g. larger for a wider fan-out over small keys
+	SubTreeOrder     int // Order of the sub trees, SubBTreeOrder by default
+	SubTreeThreshold int // Rows of a key moving it into a sub tree, SubBTreeCreationThreshold by default
+
+	// Name of the btree.Comparator ordering the keys, btree.BinaryComparator by default, e.g.
+	// btree.CaseFoldComparator, or a locale-aware collation registered with btree.RegisterComparator. Primary keys
+	// within a key are always ordered by btree.BinaryComparator.
+	Comparator string
+
+	// Order the keys from the largest to the smallest, e.g. the newest first for a timeline. Queries walking the
+	// index forwards, e.g. RangeSorted, InSorted and All, then return the largest keys first, the bounds of a range
+	// still being given in the order of the index: the lower bound is the largest key. Composite indexes set the
+	// direction of each of their fields instead.
+	Descending bool
+
+	// Allow a single primary key per key, e.g. to enforce unique email addresses. Put, Update and BulkLoad return
+	// ErrDuplicateKey for the row of another primary key, putting the same primary key and key again replaces its
+	// page. An index created unique stays unique when opened without it.
+	Unique bool
+
+	// Block sizes of the index file and the sub index files, see btree.Options
+	MetadataSize   int
+	IndexBlockSize int
+	PageBlockSize  int
+}
+
+func (options Options) withDefaults() Options {
+	if options.Order == 0 {
+		options.Order = BTreeOrder
+	}
+	if options.SubTreeOrder == 0 {
+		options.SubTreeOrder = SubBTreeOrder
+	}
+	if options.SubTreeThreshold == 0 {
+		options.SubTreeThreshold = SubBTreeCreationThreshold
+	}
+	return options
+}
+
+func (options Options) validate() error {
+	if options.SubTreeOrder < btree.MinOrder {
+		return fmt.Errorf("%w: sub tree order %d is below %d", ErrInvalidOptions, options.SubTreeOrder, btree.MinOrder)
+	}
+	if options.SubTreeThreshold < 1 {
+		return fmt.Errorf("%w: sub tree threshold %d is below 1", ErrInvalidOptions, options.SubTreeThreshold)
+	}
+	if _, ok := btree.LookupComparator(options.Comparator); !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownComparator, options.Comparator)
+	}
+	return nil
+}
+
+// indexOptions returns the options of the main index, recording the settings of the sub trees.
+func (options Options) indexOptions() btree.Options {
+	return btree.Options{
+		Order:          options.Order,
+		MetadataSize:   options.MetadataSize,
+		IndexBlockSize: options.IndexBlockSize,
+		PageBlockSize:  options.PageBlockSize,
+		Comparator:     options.Comparator,
+		Attributes: map[string]string{
+			subTreeOrderAttribute:     strconv.Itoa(options.SubTreeOrder),
+			subTreeThresholdAttribute: strconv.Itoa(options.SubTreeThreshold),
+			uniqueAttribute:           strconv.FormatBool(options.Unique),
+		},
+	}
+}
+
+// indexSettings returns the options index was created with. Indexes created before the settings of the sub trees
+// were recorded use their defaults.
+func indexSettings(index *btree.BTree[any, any]) (Options, error) {
+	settings := Options{
+		Order:            index.Order,
+		SubTreeOrder:     SubBTreeOrder,
+		SubTreeThreshold: SubBTreeCreationThreshold,
+		MetadataSize:     index.MetadataSize,
+		IndexBlockSize:   index.IndexBlockSize,
+		PageBlockSize:    index.PageBlockSize,
+		Comparator:       cmp.Or(index.Comparator, btree.BinaryComparator),
+		Descending:       slices.Equal(index.Descending, []bool{true}),
+	}
+	for name, setting := range map[string]*int{
+		subTreeOrderAttribute:     &settings.SubTreeOrder,
+		subTreeThresholdAttribute: &settings.SubTreeThreshold,
+	} {
+		if recorded, ok := index.Attributes[name]; ok {
+			value, err := strconv.Atoi(recorded)
+			if err != nil {
+				return settings, fmt.Errorf("%s records an invalid %s %q", index.IndexName, name, recorded)
+			}
+			*setting = value
+		}
+	}
+	if recorded, ok := index.Attributes[uniqueAttribute]; ok {
+		unique, err := strconv.ParseBool(recorded)
+		if err != nil {
+			return settings, fmt.Errorf("%s records an invalid %s %q", index.IndexName, uniqueAttribute, recorded)
+		}
+		settings.Unique = unique
+	}
+	return settings, nil
+}
+
+// check returns ErrOptionsMismatch when a non zero field of options differs from settings or the index records other
+// descending components.
+func (options Options) check(index *btree.BTree[any, any], settings Options, descending []bool) error {
+	indexOptions := options.indexOptions()
+	indexOptions.Descending = descending
+	if err := index.CheckOptions(indexOptions); err != nil {
+		return err
+	}
+	if options.SubTreeOrder != 0 && options.SubTreeOrder != settings.SubTreeOrder {
+		return fmt.Errorf("%w: sub tree order %d requested, %s records %d",
+			ErrOptionsMismatch, options.SubTreeOrder, index.IndexName, settings.SubTreeOrder)
+	}
+	if options.SubTreeThreshold != 0 && options.SubTreeThreshold != settings.SubTreeThreshold {
+		return fmt.Errorf("%w: sub tree threshold %d requested, %s records %d",
+			ErrOptionsMismatch, options.SubTreeThreshold, index.IndexName, settings.SubTreeThreshold)
+	}
+	if options.Unique && !settings.Unique {
+		return fmt.Errorf("%w: unique requested, %s is not unique", ErrOptionsMismatch, index.IndexName)
+	}
+	return nil
+}
+
+// subTreeOptions returns the options new sub trees of the tree are created with.
+func (tree *Tree) subTreeOptions() btree.Options {
+	return btree.Options{
+		Order:          tree.settings.SubTreeOrder,
+		MetadataSize:   tree.settings.MetadataSize,
+		IndexBlockSize: tree.settings.IndexBlockSize,
+		PageBlockSize:  tree.settings.PageBlockSize,
+	}
+}
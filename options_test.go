@@ -0,0 +1,134 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"bptree/dbmodels"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDescendingIndexQueries(t *testing.T) {
+	tree := openTestTree(t, Options{Order: 3, Descending: true})
+	for key := 0; key < 10; key++ {
+		if err := tree.Put(key, key, &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		name     string
+		keyRange KeyRange
+		want     []any
+	}{
+		{"every key", KeyRange{}, []any{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}},
+		// The lower bound of a descending index is its largest key
+		{"inclusive bounds", Between(7, 3), []any{7, 6, 5, 4, 3}},
+		{"exclusive bounds", KeyRange{}.Above(7).Below(3), []any{6, 5, 4}},
+		{"lower bound only", KeyRange{}.AtLeast(2), []any{2, 1, 0}},
+		{"bounds out of order", Between(3, 7), nil},
+	} {
+		got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeSorted(c.keyRange, limit, cursor)
+		}, 2)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: RangeSorted returned %v, want %v", c.name, got, c.want)
+		}
+
+		reversed := slices.Clone(c.want)
+		slices.Reverse(reversed)
+		got = pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.RangeReverseSorted(c.keyRange, limit, cursor)
+		}, 3)
+		if !slices.Equal(got, reversed) {
+			t.Errorf("%s: RangeReverseSorted returned %v, want %v", c.name, got, reversed)
+		}
+	}
+
+	got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+		return tree.InSorted([]any{1, 5, 12, 3}, limit, cursor)
+	}, 1)
+	if want := []any{5, 3, 1}; !slices.Equal(got, want) {
+		t.Fatalf("InSorted returned %v, want %v", got, want)
+	}
+}
+
+func TestReopenWithOtherOptions(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 4, Descending: true})
+	if err := tree.Put(1, 1, &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, options := range []Options{
+		{Order: BTreeOrder + 1},
+		{SubTreeOrder: SubBTreeOrder + 1},
+		{SubTreeThreshold: 8},
+		{Unique: true},
+		{Comparator: btree.CaseFoldComparator},
+	} {
+		if tree, err := New("collection", "field", options); !errors.Is(err, ErrOptionsMismatch) {
+			if err == nil {
+				tree.Close()
+			}
+			t.Errorf("reopened with %+v: %v", options, err)
+		}
+	}
+
+	// Options left zero, Descending among them, take the recorded ones
+	tree, err := New("collection", "field", Options{SubTreeThreshold: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if !tree.settings.Descending || tree.settings.SubTreeThreshold != 4 {
+		t.Fatalf("reopened with %+v", tree.settings)
+	}
+	if err := tree.Put(2, 2, &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, _, err := tree.RangeSorted(KeyRange{}, 10, "")
+	if err != nil || !slices.Equal(primaryKeysOf(rows), []any{2, 1}) {
+		t.Fatal("reopened index returned", primaryKeysOf(rows), err)
+	}
+}
+
+func TestReopenWithOtherDirection(t *testing.T) {
+	tree := openTestTree(t, Options{})
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tree, err := New("collection", "field", Options{Descending: true}); !errors.Is(err, ErrOptionsMismatch) {
+		if err == nil {
+			tree.Close()
+		}
+		t.Fatal("ascending index reopened descending:", err)
+	}
+}
+
+func TestReopenKeepsComparator(t *testing.T) {
+	tree := openTestTree(t, Options{Comparator: btree.CaseFoldComparator})
+	if err := tree.Put(1, "Name", &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New("collection", "field", Options{Comparator: btree.BinaryComparator}); !errors.Is(err, ErrOptionsMismatch) {
+		t.Fatal("case folded index reopened with the binary comparator:", err)
+	}
+	tree, err := New("collection", "field", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err = tree.Put(2, "NAME", &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if rows, found, err := tree.Get("name"); err != nil || !found || len(*rows) != 2 {
+		t.Fatal("keys differing in case do not share their rows", rows, err)
+	}
+}
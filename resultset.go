@@ -3,53 +3,287 @@ package bptree
 import (
 	"bptree/btree"
 	"bptree/dbmodels"
-	"os"
+	"bptree/utils"
+	"slices"
 )
 
+// ResultSet holds the rows of a key, sorted by primary key whether the key holds them itself or in a sub tree, also
+// for indexes written before the rows were kept sorted, which are sorted when read. A ResultSet returned by an Enumerator reads a sub tree as it was when the enumerator was created, and as it is once
+// the enumerator is closed.
 type ResultSet struct {
 	treeValue *any
+	tree      *Tree
+	snapshot  *btree.Snapshot // Snapshot of the Enumerator returning the ResultSet, nil to read the current rows
 }
 
-func (row *ResultSet) Has(primaryKey any) (*dbmodels.Page, bool) {
+func (row *ResultSet) Has(primaryKey any) (*dbmodels.Page, bool, error) {
+	if err := checkKeyValue(primaryKey); err != nil {
+		return nil, false, err
+	}
+	if rows, ok := keyRows(*row.treeValue); ok {
+		val, ok := rows.Get(primaryKey)
+		return val, ok, nil
+	}
 	switch value := (*row.treeValue).(type) {
-	case map[any]*dbmodels.Page:
-		val, ok := value[primaryKey]
-		return val, ok
 	case btree.BTree[any, *dbmodels.Page]:
-		subTreeFile, err := os.OpenFile(value.IndexName, os.O_RDWR, os.ModePerm)
+		subBTree, subHandle, err := row.tree.openSubBtree(value.IndexName, row.snapshot)
 		if err != nil {
-			panic(err)
+			return nil, false, err
 		}
-		defer subTreeFile.Close()
-		val, ok := value.Get(primaryKey, subTreeFile)
-		if ok {
-			return *val, ok
+		defer subHandle.release()
+		val, ok, err := subBTree.Get(primaryKey, subHandle.file)
+		if err != nil || !ok {
+			return nil, false, err
 		}
-		return nil, false
+		return *val, true, nil
 	default:
-		return nil, false
+		return nil, false, nil
 	}
 }
 
-func (row *ResultSet) ToIterable() map[any]*dbmodels.Page {
+// ToIterable returns the rows by primary key, loading a sub tree at once. Rows and SeekFirst walk them in order.
+func (row *ResultSet) ToIterable() (map[any]*dbmodels.Page, error) {
+	if rows, ok := keyRows(*row.treeValue); ok {
+		return rows.Map(), nil
+	}
 	switch existingData := (*row.treeValue).(type) {
-	case map[any]*dbmodels.Page:
-		return existingData
 	case btree.BTree[any, *dbmodels.Page]:
-		subTreeFile, err := os.OpenFile(existingData.IndexName, os.O_RDWR, os.ModePerm)
+		subBTree, subHandle, err := row.tree.openSubBtree(existingData.IndexName, row.snapshot)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		defer subTreeFile.Close()
+		defer subHandle.release()
+		subTreeFile := subHandle.file
 
 		dataMap := map[any]*dbmodels.Page{}
-		e := existingData.SeekFirst(subTreeFile)
+		e, err := subBTree.SeekFirst(subTreeFile)
+		if err != nil {
+			return nil, err
+		}
+		defer e.Close()
 		for e.HasNext() {
-			k, v := e.Next(subTreeFile)
+			k, v, err := e.Next(subTreeFile)
+			if err != nil {
+				return nil, err
+			}
 			dataMap[*k] = *v
 		}
-		return dataMap
+		return dataMap, nil
 	default:
-		return nil
+		return nil, nil
+	}
+}
+
+// SeekFirst returns an enumerator over the rows from the smallest primary key.
+func (row *ResultSet) SeekFirst() (*RowEnumerator, error) {
+	return row.seek(func(rows dbmodels.Rows) int { return 0 },
+		func(subBTree *btree.BTree[any, *dbmodels.Page], handle *fileHandle) (*btree.Enumerator[any, *dbmodels.Page], error) {
+			return subBTree.SeekFirst(handle.file)
+		})
+}
+
+// Seek returns an enumerator whose Next returns the row of primaryKey, or of the next primary key when it is
+// missing, and whose Previous returns the row before.
+func (row *ResultSet) Seek(primaryKey any) (*RowEnumerator, error) {
+	if err := checkKeyValue(primaryKey); err != nil {
+		return nil, err
+	}
+	return row.seek(func(rows dbmodels.Rows) int {
+		i, _ := rows.Search(primaryKey)
+		return i
+	}, func(subBTree *btree.BTree[any, *dbmodels.Page], handle *fileHandle) (*btree.Enumerator[any, *dbmodels.Page], error) {
+		return subBTree.Seek(primaryKey, handle.file)
+	})
+}
+
+// SeekLast returns an enumerator whose Previous walks the rows from the largest primary key.
+func (row *ResultSet) SeekLast() (*RowEnumerator, error) {
+	return row.seek(func(rows dbmodels.Rows) int { return len(rows) },
+		func(subBTree *btree.BTree[any, *dbmodels.Page], handle *fileHandle) (*btree.Enumerator[any, *dbmodels.Page], error) {
+			return subBTree.SeekLast(handle.file)
+		})
+}
+
+// seek returns an enumerator before the row at the position returned by position among the rows held by the key, or
+// over the enumerator of the sub tree returned by seekSubTree.
+func (row *ResultSet) seek(position func(rows dbmodels.Rows) int,
+	seekSubTree func(*btree.BTree[any, *dbmodels.Page], *fileHandle) (*btree.Enumerator[any, *dbmodels.Page], error)) (*RowEnumerator, error) {
+	if rows, ok := keyRows(*row.treeValue); ok {
+		return &RowEnumerator{rows: rows, next: position(rows)}, nil
+	}
+	value, ok := (*row.treeValue).(btree.BTree[any, *dbmodels.Page])
+	if !ok {
+		return &RowEnumerator{}, nil
+	}
+	subBTree, subHandle, err := row.tree.openSubBtree(value.IndexName, row.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	subEnumerator, err := seekSubTree(subBTree, subHandle)
+	if err != nil {
+		subHandle.release()
+		return nil, err
+	}
+	return &RowEnumerator{subEnumerator: subEnumerator, subHandle: subHandle}, nil
+}
+
+// RowEnumerator walks the rows of a key by primary key. It holds the sub index file of the key open until Close.
+type RowEnumerator struct {
+	rows          dbmodels.Rows // Rows held by the key itself
+	next          int           // Position of the row Next returns among rows
+	subEnumerator *btree.Enumerator[any, *dbmodels.Page]
+	subHandle     *fileHandle
+}
+
+func (enumerator *RowEnumerator) HasNext() bool {
+	if enumerator.subEnumerator != nil {
+		return enumerator.subEnumerator.HasNext()
+	}
+	return enumerator.next < len(enumerator.rows)
+}
+
+func (enumerator *RowEnumerator) HasPrevious() bool {
+	if enumerator.subEnumerator != nil {
+		return enumerator.subEnumerator.HasPrevious()
+	}
+	return enumerator.next > 0
+}
+
+// Next returns the primary key and the page of the next row, a nil page once the rows are exhausted.
+func (enumerator *RowEnumerator) Next() (any, *dbmodels.Page, error) {
+	if !enumerator.HasNext() {
+		return nil, nil, nil
+	}
+	if enumerator.subEnumerator != nil {
+		return subTreeRow(enumerator.subEnumerator.Next(enumerator.subHandle.file))
+	}
+	row := enumerator.rows[enumerator.next]
+	enumerator.next++
+	return row.PrimaryKey, row.Page, nil
+}
+
+// Previous returns the primary key and the page of the previous row, a nil page once the rows are exhausted.
+func (enumerator *RowEnumerator) Previous() (any, *dbmodels.Page, error) {
+	if !enumerator.HasPrevious() {
+		return nil, nil, nil
+	}
+	if enumerator.subEnumerator != nil {
+		return subTreeRow(enumerator.subEnumerator.Previous(enumerator.subHandle.file))
+	}
+	enumerator.next--
+	row := enumerator.rows[enumerator.next]
+	return row.PrimaryKey, row.Page, nil
+}
+
+func subTreeRow(primaryKey *any, page **dbmodels.Page, err error) (any, *dbmodels.Page, error) {
+	if err != nil || primaryKey == nil || page == nil {
+		return nil, nil, err
+	}
+	return *primaryKey, *page, nil
+}
+
+func (enumerator *RowEnumerator) Close() {
+	if enumerator.subHandle == nil {
+		return
 	}
+	enumerator.subEnumerator.Close()
+	enumerator.subHandle.release()
+	enumerator.subHandle = nil
+}
+
+// checkPrimaryKeys returns ErrUnsupportedKey when a primary key of relevantKeys is of a type the index cannot
+// order.
+func checkPrimaryKeys(relevantKeys map[any]float64) error {
+	for primaryKey := range relevantKeys {
+		if err := checkKeyValue(primaryKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedPrimaryKeys returns the primary keys of relevantKeys in order.
+func sortedPrimaryKeys(relevantKeys map[any]float64) ([]any, error) {
+	if err := checkPrimaryKeys(relevantKeys); err != nil {
+		return nil, err
+	}
+	primaryKeys := make([]any, 0, len(relevantKeys))
+	for primaryKey := range relevantKeys {
+		primaryKeys = append(primaryKeys, primaryKey)
+	}
+	slices.SortFunc(primaryKeys, utils.Compare)
+	return primaryKeys, nil
+}
+
+// TypedResultSet holds the rows of a key of a TypedTree like ResultSet.
+type TypedResultSet[PK comparable] struct {
+	rows *ResultSet
+}
+
+func (row *TypedResultSet[PK]) Has(primaryKey PK) (*dbmodels.Page, bool, error) {
+	return row.rows.Has(primaryKey)
+}
+
+func (row *TypedResultSet[PK]) ToIterable() (map[PK]*dbmodels.Page, error) {
+	rows, err := row.rows.ToIterable()
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	return typedRows[PK](rows)
+}
+
+func (row *TypedResultSet[PK]) SeekFirst() (*TypedRowEnumerator[PK], error) {
+	return typedRowEnumerator[PK](row.rows.SeekFirst())
+}
+
+func (row *TypedResultSet[PK]) Seek(primaryKey PK) (*TypedRowEnumerator[PK], error) {
+	return typedRowEnumerator[PK](row.rows.Seek(primaryKey))
+}
+
+func (row *TypedResultSet[PK]) SeekLast() (*TypedRowEnumerator[PK], error) {
+	return typedRowEnumerator[PK](row.rows.SeekLast())
+}
+
+// TypedRowEnumerator walks the rows of a key of a TypedTree like RowEnumerator.
+type TypedRowEnumerator[PK comparable] struct {
+	enumerator *RowEnumerator
+}
+
+func typedRowEnumerator[PK comparable](enumerator *RowEnumerator, err error) (*TypedRowEnumerator[PK], error) {
+	if err != nil {
+		return nil, err
+	}
+	return &TypedRowEnumerator[PK]{enumerator: enumerator}, nil
+}
+
+func (enumerator *TypedRowEnumerator[PK]) HasNext() bool {
+	return enumerator.enumerator.HasNext()
+}
+
+func (enumerator *TypedRowEnumerator[PK]) HasPrevious() bool {
+	return enumerator.enumerator.HasPrevious()
+}
+
+func (enumerator *TypedRowEnumerator[PK]) Next() (PK, *dbmodels.Page, error) {
+	return typedRow[PK](enumerator.enumerator.Next())
+}
+
+func (enumerator *TypedRowEnumerator[PK]) Previous() (PK, *dbmodels.Page, error) {
+	return typedRow[PK](enumerator.enumerator.Previous())
+}
+
+func typedRow[PK comparable](primaryKey any, page *dbmodels.Page, err error) (PK, *dbmodels.Page, error) {
+	if err != nil || page == nil {
+		var zero PK
+		return zero, nil, err
+	}
+	typedPrimaryKey, err := typedValue[PK](primaryKey)
+	if err != nil {
+		return typedPrimaryKey, nil, err
+	}
+	return typedPrimaryKey, page, nil
+}
+
+func (enumerator *TypedRowEnumerator[PK]) Close() {
+	enumerator.enumerator.Close()
 }
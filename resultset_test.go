@@ -0,0 +1,108 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"bptree/utils"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// rowsOfKey returns the primary keys of the rows of key, walked forwards or backwards.
+func rowsOfKey(t *testing.T, tree *Tree, key any, backwards bool) []any {
+	t.Helper()
+	e, err := tree.Seek(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	_, resultSet, err := e.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seek := resultSet.SeekFirst
+	if backwards {
+		seek = resultSet.SeekLast
+	}
+	rows, err := seek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	hasNext, next := rows.HasNext, rows.Next
+	if backwards {
+		hasNext, next = rows.HasPrevious, rows.Previous
+	}
+	var primaryKeys []any
+	for hasNext() {
+		primaryKey, _, err := next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		primaryKeys = append(primaryKeys, primaryKey)
+	}
+	return primaryKeys
+}
+
+func TestPrimaryKeysComeBackInOrder(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 8, SubTreeOrder: 4})
+	r := rand.New(rand.NewSource(1))
+	held := map[string][]any{
+		"inline": {5, "b", 2.5, -3, "a", int64(4)},
+		"sub":    {},
+	}
+	for i := 0; i < 60; i++ {
+		held["sub"] = append(held["sub"], r.Intn(1000))
+	}
+	for key, primaryKeys := range held {
+		for _, i := range r.Perm(len(primaryKeys)) {
+			if err := tree.Put(primaryKeys[i], key, &dbmodels.Page{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		primaryKeys = slices.SortedFunc(slices.Values(primaryKeys), utils.Compare)
+		held[key] = slices.CompactFunc(primaryKeys, func(a, b any) bool { return utils.Compare(a, b) == 0 })
+	}
+	// Rows deleted and put again keep their place
+	for _, primaryKey := range held["sub"][10:20] {
+		if _, err := tree.Delete(primaryKey, "sub"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Put(primaryKey, "sub", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for key, want := range held {
+		if got := rowsOfKey(t, tree, key, false); !slices.Equal(got, want) {
+			t.Fatalf("%s: rows walked forwards as %v, want %v", key, got, want)
+		}
+		reversed := slices.Clone(want)
+		slices.Reverse(reversed)
+		if got := rowsOfKey(t, tree, key, true); !slices.Equal(got, reversed) {
+			t.Fatalf("%s: rows walked backwards as %v, want %v", key, got, reversed)
+		}
+
+		got := pageThrough(t, func(limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+			return tree.InSorted([]any{key}, limit, cursor)
+		}, 4)
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s: InSorted returned %v, want %v", key, got, want)
+		}
+
+		var scanErr, rowsErr error
+		got = nil
+		for _, resultSet := range tree.Scan(Between(key, key), &scanErr) {
+			for primaryKey := range resultSet.Rows(&rowsErr) {
+				got = append(got, primaryKey)
+			}
+			if rowsErr != nil {
+				t.Fatal(key, rowsErr)
+			}
+		}
+		if scanErr != nil || !slices.Equal(got, want) {
+			t.Fatalf("%s: Rows returned %v, want %v: %v", key, got, want, scanErr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"iter"
+)
+
+// Scan returns an iterator over the keys of keyRange and their rows in the order of the index, e.g.
+//
+//	var err error
+//	for key, rows := range tree.Scan(Between(from, to), &err) {
+//		...
+//	}
+//	if err != nil {
+//		...
+//	}
+//
+// The index is read as the loop goes, as it was when the loop started like an Enumerator, and the file handles it
+// holds are released when the loop ends, also on break.
+// An error reading the index ends the loop and is stored in *err, which is set to nil otherwise.
+func (tree *Tree) Scan(keyRange KeyRange, err *error) iter.Seq2[any, *ResultSet] {
+	return func(yield func(any, *ResultSet) bool) {
+		*err = nil
+		e, seekErr := tree.SeekRange(keyRange)
+		if seekErr != nil {
+			*err = seekErr
+			return
+		}
+		defer e.Close()
+
+		for e.HasNext() {
+			key, rows, nextErr := e.Next()
+			if nextErr != nil {
+				*err = nextErr
+				return
+			}
+			if !yield(*key, rows) {
+				return
+			}
+		}
+	}
+}
+
+// Rows returns an iterator over the primary keys and the pages of the rows by primary key, reading a sub tree as the
+// loop goes instead of loading it like ToIterable. Errors are reported in *err like Tree.Scan. Rows overwrites *err,
+// setting it to nil when the loop starts, so a loop over Rows within a loop over Tree.Scan needs an error variable of
+// its own.
+func (row *ResultSet) Rows(err *error) iter.Seq2[any, *dbmodels.Page] {
+	return func(yield func(any, *dbmodels.Page) bool) {
+		*err = nil
+		e, seekErr := row.SeekFirst()
+		if seekErr != nil {
+			*err = seekErr
+			return
+		}
+		defer e.Close()
+
+		for e.HasNext() {
+			primaryKey, page, nextErr := e.Next()
+			if nextErr != nil {
+				*err = nextErr
+				return
+			}
+			if !yield(primaryKey, page) {
+				return
+			}
+		}
+	}
+}
+
+// Scan returns an iterator over the keys of keyRange and their rows like Tree.Scan.
+func (tree *TypedTree[K, PK]) Scan(keyRange TypedKeyRange[K], err *error) iter.Seq2[K, *TypedResultSet[PK]] {
+	return func(yield func(K, *TypedResultSet[PK]) bool) {
+		for key, rows := range tree.tree.Scan(keyRange.Untyped(), err) {
+			typedKey, typedErr := typedValue[K](key)
+			if typedErr != nil {
+				*err = typedErr
+				return
+			}
+			if !yield(typedKey, &TypedResultSet[PK]{rows: rows}) {
+				return
+			}
+		}
+	}
+}
+
+// Rows returns an iterator over the rows by primary key like ResultSet.Rows.
+func (row *TypedResultSet[PK]) Rows(err *error) iter.Seq2[PK, *dbmodels.Page] {
+	return func(yield func(PK, *dbmodels.Page) bool) {
+		for primaryKey, page := range row.rows.Rows(err) {
+			typedPrimaryKey, typedErr := typedValue[PK](primaryKey)
+			if typedErr != nil {
+				*err = typedErr
+				return
+			}
+			if !yield(typedPrimaryKey, page) {
+				return
+			}
+		}
+	}
+}
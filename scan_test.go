@@ -0,0 +1,70 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"errors"
+	"os"
+	"testing"
+)
+
+// openHandles returns the references held on the index file beyond the tree's own and on the cached sub index
+// files beyond the cache's own.
+func openHandles(tree *Tree) int {
+	tree.subFiles.lock.Lock()
+	defer tree.subFiles.lock.Unlock()
+
+	refs := int(tree.handle.refs.Load()) - 1
+	for _, element := range tree.subFiles.handles {
+		refs += int(element.Value.(*cachedHandle).handle.refs.Load()) - 1
+	}
+	return refs
+}
+
+func TestBreakingOutOfScanReleasesHandles(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 2})
+	for key := 0; key < 5; key++ {
+		for primaryKey := 0; primaryKey < 4; primaryKey++ {
+			if err := tree.Put(primaryKey, key, &dbmodels.Page{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Every key holds a sub tree, the loops stop in the middle of the rows of the second key
+	var scanErr, rowsErr error
+	keys := 0
+scan:
+	for _, resultSet := range tree.Scan(KeyRange{}, &scanErr) {
+		if keys++; openHandles(tree) == 0 {
+			t.Fatal("Scan holds no handle on the index file")
+		}
+		for primaryKey := range resultSet.Rows(&rowsErr) {
+			if keys == 2 && primaryKey == 1 {
+				break scan
+			}
+		}
+		if rowsErr != nil {
+			t.Fatal(rowsErr)
+		}
+	}
+	if scanErr != nil || keys != 2 {
+		t.Fatal(keys, scanErr)
+	}
+	if refs := openHandles(tree); refs != 0 || tree.pool.HasSnapshots() {
+		t.Fatalf("%d handles and snapshots %v left after break", refs, tree.pool.HasSnapshots())
+	}
+
+	// The index file can be closed and its sub index files removed right away
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(IndexDirectory); err != nil {
+		t.Fatal(err)
+	}
+	for range tree.Scan(KeyRange{}, &scanErr) {
+		t.Fatal("closed tree scanned")
+	}
+	if !errors.Is(scanErr, ErrClosed) {
+		t.Fatal(scanErr)
+	}
+}
@@ -0,0 +1,94 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"errors"
+	"os"
+)
+
+// retiredFile is a sub index file removed or replaced at version while snapshots taken before were open, it is
+// kept open for them until they are released.
+type retiredFile struct {
+	indexName string
+	handle    *fileHandle
+	version   uint64
+}
+
+// openSubFile opens the sub index file indexName as seen by snapshot: the file retired first after the snapshot was
+// taken, or else the current one, also once the tree is closed. It returns the snapshot to read the file at, nil once
+// snapshot is released.
+func (tree *Tree) openSubFile(indexName string, snapshot *btree.Snapshot) (*fileHandle, *btree.Snapshot, error) {
+	if snapshot != nil {
+		tree.snapshotLock.Lock()
+		if snapshot.Released() {
+			snapshot = nil
+		} else {
+			var seen *retiredFile
+			for i, retired := range tree.retired {
+				if retired.indexName == indexName && retired.version > snapshot.Version() && (seen == nil || retired.version < seen.version) {
+					seen = &tree.retired[i]
+				}
+			}
+			if seen != nil {
+				// The snapshot is open, so the handle is not released before it is acquired
+				handle := seen.handle.acquire()
+				tree.snapshotLock.Unlock()
+				return handle, snapshot, nil
+			}
+		}
+		tree.snapshotLock.Unlock()
+	}
+
+	handle, err := tree.subFiles.open(indexName, os.O_RDWR)
+	if errors.Is(err, ErrClosed) && snapshot != nil {
+		// An enumerator left open by Close reads the file as Close checkpointed it, outside the closed cache
+		file, err := openIndexFile(indexName, os.O_RDONLY)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newFileHandle(file), snapshot, nil
+	}
+	return handle, snapshot, err
+}
+
+// retireSubFile keeps the sub index file indexName open for the open snapshots before it is removed or replaced,
+// callers must hold the write lock.
+func (tree *Tree) retireSubFile(indexName string) error {
+	if !tree.pool.HasSnapshots() {
+		return nil
+	}
+
+	handle, err := tree.subFiles.open(indexName, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	// Pages still dirty in the pool are discarded with the file
+	if err = tree.pool.Flush(handle.file); err != nil {
+		handle.release()
+		return err
+	}
+	version := tree.pool.Retire(handle.file)
+
+	tree.snapshotLock.Lock()
+	defer tree.snapshotLock.Unlock()
+
+	tree.retired = append(tree.retired, retiredFile{indexName: indexName, handle: handle, version: version})
+	return nil
+}
+
+// releaseRetired closes the retired sub index files no open snapshot reads any more.
+func (tree *Tree) releaseRetired() {
+	tree.snapshotLock.Lock()
+	defer tree.snapshotLock.Unlock()
+
+	kept := tree.retired[:0]
+	for _, retired := range tree.retired {
+		if tree.pool.SnapshotBefore(retired.version) {
+			kept = append(kept, retired)
+		} else {
+			retired.handle.release()
+		}
+	}
+	clear(tree.retired[len(kept):])
+	tree.retired = kept
+}
@@ -0,0 +1,160 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// rowsByKey holds the primary keys of the rows of every key.
+type rowsByKey map[int][]int
+
+// scanRows returns the rows of the keys of keyRange, reading the rows of each key as the scan goes.
+func scanRows(t *testing.T, tree *Tree, e *Enumerator) rowsByKey {
+	t.Helper()
+	defer e.Close()
+
+	rows := rowsByKey{}
+	for e.HasNext() {
+		key, resultSet, err := e.Next()
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		for primaryKey := range resultSet.Rows(&err) {
+			rows[(*key).(int)] = append(rows[(*key).(int)], primaryKey.(int))
+		}
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+	}
+	return rows
+}
+
+// within returns the rows of the keys from lower to upper.
+func (rows rowsByKey) within(lower int, upper int) rowsByKey {
+	selected := rowsByKey{}
+	for key, primaryKeys := range rows {
+		if key >= lower && key <= upper && len(primaryKeys) > 0 {
+			selected[key] = slices.Sorted(slices.Values(primaryKeys))
+		}
+	}
+	return selected
+}
+
+func TestEnumeratorReadsSnapshotDuringWrites(t *testing.T) {
+	tree := openTestTree(t, Options{Order: 4, SubTreeThreshold: 3, SubTreeOrder: 3})
+	r := rand.New(rand.NewSource(1))
+	rows := rowsByKey{}
+	for primaryKey := 0; primaryKey < 300; primaryKey++ {
+		key := r.Intn(40)
+		if err := tree.Put(primaryKey, key, &dbmodels.Page{DataOffset: int64(primaryKey)}); err != nil {
+			t.Fatal(err)
+		}
+		rows[key] = append(rows[key], primaryKey)
+	}
+
+	for round := 0; round < 5; round++ {
+		want := rows.within(10, 30)
+		e, err := tree.SeekRange(Between(10, 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Rows are put, keys emptied, dropping their sub trees, and the index compacted while the scan reads
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 60; i++ {
+				key := r.Intn(40)
+				switch {
+				case i%20 == 19:
+					if err := tree.Compact(); err != nil {
+						t.Error(err)
+						return
+					}
+				case i%3 == 0:
+					for _, primaryKey := range rows[key] {
+						if _, err := tree.Delete(primaryKey, key); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+					delete(rows, key)
+				default:
+					primaryKey := 1000*(round+1) + i
+					if err := tree.Put(primaryKey, key, &dbmodels.Page{DataOffset: int64(primaryKey)}); err != nil {
+						t.Error(err)
+						return
+					}
+					rows[key] = append(rows[key], primaryKey)
+				}
+			}
+		}()
+		got := scanRows(t, tree, e)
+		wg.Wait()
+
+		if !maps.EqualFunc(got, want, slices.Equal) {
+			t.Fatalf("round %d: scan returned %v, want %v", round, got, want)
+		}
+		// A scan started after the writes reads them
+		e, err = tree.SeekRange(Between(10, 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want = scanRows(t, tree, e), rows.within(10, 30); !maps.EqualFunc(got, want, slices.Equal) {
+			t.Fatalf("round %d: scan after the writes returned %v, want %v", round, got, want)
+		}
+	}
+	if len(tree.retired) != 0 {
+		t.Fatal(len(tree.retired), "retired sub index files left open")
+	}
+}
+
+func TestRangeScanDuringCompact(t *testing.T) {
+	tree := openTestTree(t, Options{Order: 64})
+	for key := 0; key < 200; key++ {
+		if err := tree.Put(key, key, &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The scans compare keys against the upper bound while Compact swaps the index, run with -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := tree.Put(1000+i, i, &dbmodels.Page{}); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := tree.Compact(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for scan := 0; scan < 200; scan++ {
+		e, err := tree.SeekRange(Between(0, 150))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := 0
+		for ; e.HasNext(); keys++ {
+			if key, _, err := e.Next(); err != nil || (*key).(int) != keys {
+				t.Fatal(keys, key, err)
+			}
+		}
+		e.Close()
+		if keys != 151 {
+			t.Fatal(keys, "keys scanned")
+		}
+	}
+	wg.Wait()
+}
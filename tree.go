@@ -3,15 +3,23 @@ package bptree
 import (
 	"bptree/btree"
 	"bptree/dbmodels"
-	"bptree/utils"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 )
 
+// BTreeOrder, SubBTreeOrder and SubBTreeCreationThreshold are the defaults of Options.
 const (
 	BTreeOrder                = 32
 	SubBTreeOrder             = 16
 	SubBTreeCreationThreshold = 16
+	SubIndexFileCacheSize     = 64
+	PagePoolSize              = 1024
 )
 
 type Tree struct {
@@ -20,192 +28,683 @@ type Tree struct {
 	indexFile      string
 	collectionName string
 	fieldName      string
+	writes         uint64       // Number of mutations, lets an online compaction detect concurrent writes
+	handle         *fileHandle  // Open index file, nil once the tree is closed
+	subFiles       *handleCache // Recently used sub index files kept open
+	pool           *btree.PagePool
+	settings       Options      // Options the index was created with
+	fields         []IndexField // Fields of a composite index, nil when the keys are single values
+	snapshotLock   sync.Mutex
+	retired        []retiredFile // Sub index files removed or replaced while enumerators were reading them
 }
 
-func indexFileExists(file *os.File) bool {
+func init() {
+	// Values of the main index are stored as interfaces, gob needs their concrete types registered
+	gob.Register(dbmodels.Rows{})
+	gob.Register(map[any]*dbmodels.Page{})
+	gob.Register(btree.BTree[any, *dbmodels.Page]{})
+}
+
+func indexFileExists(file *os.File) (bool, error) {
 	fileInfo, err := file.Stat()
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	if fileInfo.Size() <= 0 {
-		return false
+		return false, nil
 	}
-	return true
+	return true, nil
 }
 
-func newSubBtree(indexName string) (*btree.BTree[any, *dbmodels.Page], *os.File) {
-	file, err := os.OpenFile(indexName, os.O_CREATE|os.O_RDWR, os.ModePerm)
+func (tree *Tree) newSubBtree(indexName string) (*btree.BTree[any, *dbmodels.Page], *fileHandle, error) {
+	handle, err := tree.subFiles.open(indexName, os.O_CREATE|os.O_RDWR)
 
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	var tree *btree.BTree[any, *dbmodels.Page]
+	subTree, err := openOrCreateBtree[*dbmodels.Page](indexName, tree.subTreeOptions(), tree.index.PageCodec(), handle.file)
+	if err != nil {
+		handle.release()
+		return nil, nil, err
+	}
+	subTree.UsePagePool(tree.pool)
 
-	if indexFileExists(file) {
-		tree = btree.ReadMetadata[any, *dbmodels.Page](file)
-	} else {
-		tree = btree.NewTree[any, *dbmodels.Page](indexName, SubBTreeOrder, file)
+	return subTree, handle, nil
+
+}
+
+// openOrCreateBtree replays the write-ahead log of file and reads the tree in it, or writes a new tree laid out by
+// options with pages encoded by codec into it when the file is empty.
+func openOrCreateBtree[TValue any](indexName string, options btree.Options, codec btree.PageCodec, file *os.File) (*btree.BTree[any, TValue], error) {
+	if err := btree.ReplayLog(file); err != nil {
+		return nil, err
+	}
+
+	exists, err := indexFileExists(file)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return btree.ReadMetadata[any, TValue](file)
+	}
+	return btree.NewTreeWithCodec[any, TValue](indexName, options, codec, file)
+}
+
+// openSubBtree opens an existing sub tree as it was when snapshot was taken, or as it is for a nil or released
+// snapshot. The returned handle must be released by the caller. Its metadata is always read from the sub index
+// file, the copy kept as value in the main index only identifies the file and may be stale after a crash.
+func (tree *Tree) openSubBtree(indexName string, snapshot *btree.Snapshot) (*btree.BTree[any, *dbmodels.Page], *fileHandle, error) {
+	handle, snapshot, err := tree.openSubFile(indexName, snapshot)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subTree, err := btree.ReadMetadataAt[any, *dbmodels.Page](handle.file, snapshot)
+	if err != nil {
+		handle.release()
+		return nil, nil, err
+	}
+	subTree.UsePagePool(tree.pool)
+	return subTree, handle, nil
+}
+
+// recoverSubIndexFiles replays the write-ahead logs left behind by the sub trees of an index.
+func recoverSubIndexFiles(collectionName string, fieldName string) error {
+	logs, err := filepath.Glob(btree.WalFile(subIndexFileName(collectionName, fieldName, "*")))
+	if err != nil {
+		return err
 	}
 
-	return tree, file
+	for _, walFile := range logs {
+		file, err := os.OpenFile(strings.TrimSuffix(walFile, btree.WalFile("")), os.O_RDWR, os.ModePerm)
+		if errors.Is(err, os.ErrNotExist) {
+			// The sub tree was removed before its log was
+			if err = os.Remove(walFile); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = btree.ReplayLog(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// New opens the index of fieldName in collectionName, creating it laid out by options when it does not exist yet.
+// The index file stays open until Close is called, as do the last SubIndexFileCacheSize sub index files used.
+func New(collectionName string, fieldName string, options Options) (*Tree, error) {
+	var descending []bool
+	if options.Descending {
+		descending = []bool{true}
+	}
+	return open(collectionName, fieldName, options, descending)
 }
 
-func New(collectionName string, fieldName string) *Tree {
+// open opens the index of fieldName in collectionName, whose keys have the descending components recorded in its
+// metadata. Nil descending components accept whatever an existing index records.
+func open(collectionName string, fieldName string, options Options, descending []bool) (*Tree, error) {
+	if err := options.withDefaults().validate(); err != nil {
+		return nil, err
+	}
+
 	indexName := IndexFile(collectionName, fieldName)
-	file, err := os.OpenFile(indexName, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	file, err := openIndexFile(indexName, os.O_CREATE|os.O_RDWR)
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	var tree *btree.BTree[any, any]
-
-	if indexFileExists(file) {
-		tree = btree.ReadMetadata[any, any](file)
-	} else {
-		tree = btree.NewTree[any, any](indexName, BTreeOrder, file)
+	if err = recoverSubIndexFiles(collectionName, fieldName); err != nil {
+		file.Close()
+		return nil, err
+	}
+	indexOptions := options.withDefaults().indexOptions()
+	indexOptions.Descending = descending
+	tree, err := openOrCreateBtree[any](indexName, indexOptions, btree.BinaryCodec, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	settings, err := indexSettings(tree)
+	if err == nil {
+		err = options.check(tree, settings, descending)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	defer file.Close()
+	pool := btree.NewPagePool(PagePoolSize)
+	tree.UsePagePool(pool)
 	return &Tree{
 		index:          tree,
 		collectionName: collectionName,
 		fieldName:      fieldName,
 		indexFile:      indexName,
-	}
+		handle:         newFileHandle(file),
+		subFiles:       newHandleCache(SubIndexFileCacheSize, pool.Flush),
+		pool:           pool,
+		settings:       settings,
+	}, nil
 }
 
-func (tree *Tree) Put(primaryKeyValue any, key any, page *dbmodels.Page) {
-	file, err := os.OpenFile(tree.indexFile, os.O_RDWR, os.ModePerm)
+// Close checkpoints the index file and the open sub index files and releases them. Enumerators still open keep
+// reading until they are closed themselves, every other method returns ErrClosed afterwards.
+func (tree *Tree) Close() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
 
-	if err != nil {
-		panic(err)
+	if tree.handle == nil {
+		return ErrClosed
+	}
+
+	checkpoint := func(file *os.File) error {
+		return btree.Checkpoint(file, tree.pool)
+	}
+	err := errors.Join(
+		checkpoint(tree.handle.file),
+		tree.subFiles.close(checkpoint),
+		tree.handle.release(),
+	)
+	tree.handle = nil
+	return err
+}
+
+// openFile returns the open index file, callers must hold the tree lock.
+func (tree *Tree) openFile() (*os.File, error) {
+	if tree.handle == nil {
+		return nil, ErrClosed
 	}
-	defer file.Close()
+	return tree.handle.file, nil
+}
 
+func (tree *Tree) Put(primaryKeyValue any, key any, page *dbmodels.Page) error {
 	tree.lock.Lock()
 	defer tree.lock.Unlock()
 
-	if existingData, exists := tree.index.Get(key, file); exists {
+	file, err := tree.openFile()
+	if err != nil {
+		return err
+	}
+
+	return tree.put(primaryKeyValue, key, page, file)
+}
+
+func (tree *Tree) put(primaryKeyValue any, key any, page *dbmodels.Page, file *os.File) error {
+	if err := tree.checkRow(primaryKeyValue, key); err != nil {
+		return err
+	}
+	tree.writes++
+	existingData, exists, err := tree.index.Get(key, file)
+	if err != nil {
+		return err
+	}
+	if exists {
 		dataIndex := *existingData
-		tree.resolveBtreeValueAndPut(primaryKeyValue, key, page, dataIndex, file)
-	} else {
-		tree.index.Put(key, map[any]*dbmodels.Page{primaryKeyValue: page}, file)
+		if tree.settings.Unique {
+			if !holdsOnly(dataIndex, primaryKeyValue) {
+				return duplicateKeyError(key)
+			}
+			// Putting the row again replaces its page
+			return tree.index.Put(key, dbmodels.Rows{{PrimaryKey: primaryKeyValue, Page: page}}, file)
+		}
+		return tree.resolveBtreeValueAndPut(primaryKeyValue, key, page, dataIndex, file)
 	}
+	return tree.index.Put(key, dbmodels.Rows{{PrimaryKey: primaryKeyValue, Page: page}}, file)
+}
+
+// checkRow returns ErrUnsupportedKey or ErrKeyType when the row of primaryKeyValue cannot be stored under key.
+func (tree *Tree) checkRow(primaryKeyValue any, key any) error {
+	if err := tree.checkKey(key); err != nil {
+		return err
+	}
+	return checkKeyValue(primaryKeyValue)
+}
+
+// holdsOnly tells whether the value of a key of a unique index holds the row of primaryKeyValue and no other.
+func holdsOnly(value any, primaryKeyValue any) bool {
+	rows, ok := keyRows(value)
+	if !ok || len(rows) != 1 {
+		return false
+	}
+	_, ok = rows.Get(primaryKeyValue)
+	return ok
+}
+
+// keyRows returns the rows held by the value of a key itself, false for a sub tree. The maps of primary keys to pages
+// of indexes written before the rows of a key were kept sorted are sorted on the fly.
+func keyRows(value any) (dbmodels.Rows, bool) {
+	switch rows := value.(type) {
+	case dbmodels.Rows:
+		return rows, true
+	case map[any]*dbmodels.Page:
+		return dbmodels.RowsOf(rows), true
+	}
+	return nil, false
+}
+
+func duplicateKeyError(key any) error {
+	return fmt.Errorf("%w: %v", ErrDuplicateKey, key)
 }
 
 func (tree *Tree) resolveBtreeValueAndPut(primaryKeyValue any, key any, page *dbmodels.Page,
-	value any, file *os.File) {
+	value any, file *os.File) error {
+	if rows, ok := keyRows(value); ok {
+		value = rows
+	}
 	switch existingValue := value.(type) {
-	case map[any]*dbmodels.Page:
-		if primaryKeyValue == key && len(existingValue) < SubBTreeCreationThreshold-1 {
-			existingValue[primaryKeyValue] = page
-			tree.index.Put(key, existingValue, file)
+	case dbmodels.Rows:
+		// Like BulkLoad, a key holds its rows itself until they reach the sub tree threshold
+		updatedValue := existingValue.With(primaryKeyValue, page)
+		if len(updatedValue) < tree.settings.SubTreeThreshold {
+			return tree.index.Put(key, updatedValue, file)
 		} else {
-			subBTree, subFile := newSubBtree(SubIndexFile(tree.collectionName, tree.fieldName, key))
-			for primaryKey, location := range existingValue {
-				subBTree.Put(primaryKey, location, subFile)
+			indexName, err := SubIndexFile(tree.collectionName, tree.fieldName, key)
+			if err != nil {
+				return err
+			}
+			subBTree, subHandle, err := tree.newSubBtree(indexName)
+			if err != nil {
+				return err
 			}
-			subBTree.Put(primaryKeyValue, page, subFile)
-			subFile.Close()
+			defer subHandle.release()
+			subFile := subHandle.file
 
-			tree.index.Put(key, subBTree, file)
+			for _, row := range updatedValue {
+				if err = subBTree.Put(row.PrimaryKey, row.Page, subFile); err != nil {
+					return err
+				}
+			}
+
+			return tree.index.Put(key, *subBTree, file)
 		}
 	case btree.BTree[any, *dbmodels.Page]:
-		subTreeFile, err := os.OpenFile(existingValue.IndexName, os.O_RDWR, os.ModePerm)
+		subBTree, subHandle, err := tree.openSubBtree(existingValue.IndexName, nil)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		existingValue.Put(primaryKeyValue, page, subTreeFile)
-		subTreeFile.Close()
-		tree.index.Put(key, existingValue, file)
+		defer subHandle.release()
+		return subBTree.Put(primaryKeyValue, page, subHandle.file)
 	}
+	return nil
 }
 
-func (tree *Tree) Get(key any) (*map[any]*dbmodels.Page, bool) {
-	tree.lock.RLock()
-	defer tree.lock.RUnlock()
+// Delete removes the row of primaryKeyValue stored under key. The key is dropped from the index once it holds
+// no more rows and the sub index file of the key is removed once its sub tree becomes empty.
+func (tree *Tree) Delete(primaryKeyValue any, key any) (bool, error) {
+	if err := tree.checkRow(primaryKeyValue, key); err != nil {
+		return false, err
+	}
 
-	file, err := os.OpenFile(tree.indexFile, os.O_RDWR, os.ModePerm)
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
 
+	file, err := tree.openFile()
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-	defer file.Close()
 
-	existingData, exists := tree.index.Get(key, file)
-	if !exists {
-		return nil, false
+	return tree.remove(primaryKeyValue, key, file)
+}
+
+// Update moves the row of primaryKeyValue from oldKey to newKey with the given page. Both halves run under the
+// write lock, so readers never observe the row under both keys or under neither. Returns false when the row is
+// not present under oldKey, in which case nothing is changed. The row is put under newKey before it is removed from
+// oldKey, so a failure in between is undone and a crash in between leaves the row under both keys.
+func (tree *Tree) Update(primaryKeyValue any, oldKey any, newKey any, page *dbmodels.Page) (bool, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	file, err := tree.openFile()
+	if err != nil {
+		return false, err
 	}
 
-	switch value := (*existingData).(type) {
-	case map[any]*dbmodels.Page:
-		return &value, true
+	if err = tree.checkRow(primaryKeyValue, newKey); err != nil {
+		return false, err
+	}
+	if err = tree.checkKey(oldKey); err != nil {
+		return false, err
+	}
+	if _, exists, err := tree.rowPage(primaryKeyValue, oldKey); err != nil || !exists {
+		return false, err
+	}
+	if tree.index.Compare(oldKey, newKey) == 0 {
+		return true, tree.put(primaryKeyValue, newKey, page, file)
+	}
+
+	previousPage, hadRow, err := tree.rowPage(primaryKeyValue, newKey)
+	if err != nil {
+		return false, err
+	}
+	if err = tree.put(primaryKeyValue, newKey, page, file); err != nil {
+		return false, err
+	}
+	if _, err = tree.remove(primaryKeyValue, oldKey, file); err != nil {
+		// Leave newKey as it was
+		var undoErr error
+		if hadRow {
+			undoErr = tree.put(primaryKeyValue, newKey, previousPage, file)
+		} else {
+			_, undoErr = tree.remove(primaryKeyValue, newKey, file)
+		}
+		return false, errors.Join(err, undoErr)
+	}
+	return true, nil
+}
+
+// rowPage returns the page of the row of primaryKeyValue under key, callers must hold the tree lock.
+func (tree *Tree) rowPage(primaryKeyValue any, key any) (*dbmodels.Page, bool, error) {
+	resultSet, exists, err := tree.getResultSet(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return resultSet.Has(primaryKeyValue)
+}
+
+func (tree *Tree) remove(primaryKeyValue any, key any, file *os.File) (bool, error) {
+	tree.writes++
+	existingData, exists, err := tree.index.Get(key, file)
+	if err != nil || !exists {
+		return false, err
+	}
+	return tree.resolveBtreeValueAndDelete(primaryKeyValue, key, *existingData, file)
+}
+
+func (tree *Tree) resolveBtreeValueAndDelete(primaryKeyValue any, key any, value any, file *os.File) (bool, error) {
+	if rows, ok := keyRows(value); ok {
+		value = rows
+	}
+	switch existingValue := value.(type) {
+	case dbmodels.Rows:
+		if _, ok := existingValue.Get(primaryKeyValue); !ok {
+			return false, nil
+		}
+		// Pages in the pool share the rows, Without returns a copy
+		updatedValue := existingValue.Without(primaryKeyValue)
+		if len(updatedValue) == 0 {
+			if _, err := tree.index.Delete(key, file); err != nil {
+				return false, err
+			}
+		} else {
+			if err := tree.index.Put(key, updatedValue, file); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
 	case btree.BTree[any, *dbmodels.Page]:
-		subTreeFile, err := os.OpenFile(value.IndexName, os.O_RDWR, os.ModePerm)
+		subBTree, subHandle, err := tree.openSubBtree(existingValue.IndexName, nil)
 		if err != nil {
-			panic(err)
+			return false, err
+		}
+		deleted, err := subBTree.Delete(primaryKeyValue, subHandle.file)
+		subHandle.release()
+		if err != nil || !deleted {
+			return false, err
 		}
-		defer subTreeFile.Close()
-		e := value.SeekFirst(subTreeFile)
-		dataMap := map[any]*dbmodels.Page{}
-		for e.HasNext() {
-			k, v := e.Next(subTreeFile)
-			dataMap[*k] = *v
+
+		if subBTree.IsEmpty() {
+			if _, err = tree.index.Delete(key, file); err != nil {
+				return false, err
+			}
+			if err = tree.retireSubFile(subBTree.IndexName); err != nil {
+				return false, err
+			}
+			tree.pool.Discard(subBTree.IndexName)
+			tree.subFiles.evict(subBTree.IndexName)
+			if err = os.Remove(subBTree.IndexName); err != nil {
+				return false, err
+			}
+			if err = os.Remove(btree.WalFile(subBTree.IndexName)); err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
 		}
-		return &dataMap, true
+		return true, nil
 	default:
-		return nil, false
+		return false, nil
 	}
 }
 
-func (tree *Tree) SeekFirst() *Enumerator {
+// Compact rewrites the index file and every sub index file densely and swaps them in. The files are rebuilt
+// while readers keep using the current ones, writers are held off only for the swap. If rows changed in the
+// meantime the files are rebuilt once more while holding the write lock.
+func (tree *Tree) Compact() error {
 	tree.lock.RLock()
-	defer tree.lock.RUnlock()
+	if tree.handle == nil {
+		tree.lock.RUnlock()
+		return ErrClosed
+	}
+	writes := tree.writes
+	compacted, subTrees, err := tree.writeCompacted()
+	tree.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+
+	if tree.writes != writes {
+		if err = discardCompacted(compacted, subTrees); err != nil {
+			return err
+		}
+		if compacted, subTrees, err = tree.writeCompacted(); err != nil {
+			return err
+		}
+	}
+
+	// Sub trees go first, the main index still refers to their old layout until it is swapped itself
+	for _, subTree := range subTrees {
+		if err = tree.retireSubFile(subTree.IndexName); err != nil {
+			return err
+		}
+		if err = subTree.CommitCompacted(); err != nil {
+			return err
+		}
+	}
+	return tree.commitIndex(compacted)
+}
 
-	file, err := os.OpenFile(tree.indexFile, os.O_RDWR, os.ModePerm)
+// commitIndex swaps the index written into its compact file in and reopens the index file, callers must hold the
+// write lock.
+func (tree *Tree) commitIndex(compacted *btree.BTree[any, any]) error {
+	if err := compacted.CommitCompacted(); err != nil {
+		return err
+	}
+	tree.index = compacted
 
+	// The open handles refer to the files replaced by the swap, enumerators keep reading the replaced index file
+	tree.pool.Retire(tree.handle.file)
+	tree.subFiles.evictAll()
+	tree.handle.release()
+	file, err := openIndexFile(tree.indexFile, os.O_RDWR)
 	if err != nil {
-		panic(err)
+		tree.handle = nil
+		return err
 	}
+	tree.handle = newFileHandle(file)
+	return nil
+}
 
-	return &Enumerator{btreeEnumerator: tree.index.SeekFirst(file), file: file}
+func discardCompacted(compacted *btree.BTree[any, any], subTrees []*btree.BTree[any, *dbmodels.Page]) error {
+	for _, subTree := range subTrees {
+		if err := subTree.DiscardCompacted(); err != nil {
+			return err
+		}
+	}
+	return compacted.DiscardCompacted()
 }
 
-func (tree *Tree) Seek(key any) *Enumerator {
+func (tree *Tree) writeCompacted() (*btree.BTree[any, any], []*btree.BTree[any, *dbmodels.Page], error) {
+	file, err := tree.openFile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var subTrees []*btree.BTree[any, *dbmodels.Page]
+	compacted, err := tree.index.WriteCompacted(file, func(key any, value any) (any, error) {
+		subTree, isSubTree := value.(btree.BTree[any, *dbmodels.Page])
+		if !isSubTree {
+			return value, nil
+		}
+
+		subBTree, subHandle, err := tree.openSubBtree(subTree.IndexName, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer subHandle.release()
+
+		compactedSubTree, err := subBTree.WriteCompacted(subHandle.file, nil)
+		if err != nil {
+			return nil, err
+		}
+		subTrees = append(subTrees, compactedSubTree)
+		return *compactedSubTree, nil
+	})
+	if err != nil {
+		for _, subTree := range subTrees {
+			subTree.DiscardCompacted()
+		}
+		return nil, nil, err
+	}
+	return compacted, subTrees, nil
+}
+
+func (tree *Tree) Get(key any) (*map[any]*dbmodels.Page, bool, error) {
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
-	file, err := os.OpenFile(tree.indexFile, os.O_RDWR, os.ModePerm)
 
+	return tree.get(key)
+}
+
+// get reads the rows of key without locking, callers must hold the tree lock.
+func (tree *Tree) get(key any) (*map[any]*dbmodels.Page, bool, error) {
+	resultSet, exists, err := tree.getResultSet(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	dataMap, err := resultSet.ToIterable()
+	if err != nil || dataMap == nil {
+		return nil, false, err
+	}
+	return &dataMap, true, nil
+}
+
+// getResultSet reads the ResultSet of key without locking, callers must hold the tree lock.
+func (tree *Tree) getResultSet(key any) (*ResultSet, bool, error) {
+	if err := checkQueryKey(key); err != nil {
+		return nil, false, err
+	}
+
+	file, err := tree.openFile()
 	if err != nil {
-		panic(err)
+		return nil, false, err
 	}
 
-	return &Enumerator{btreeEnumerator: tree.index.Seek(key, file), file: file}
+	existingData, exists, err := tree.index.Get(key, file)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	return &ResultSet{treeValue: existingData, tree: tree}, true, nil
 }
 
-func (tree *Tree) SeekLast() *Enumerator {
+func (tree *Tree) SeekFirst() (*Enumerator, error) {
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
-	file, err := os.OpenFile(tree.indexFile, os.O_RDWR, os.ModePerm)
 
+	return tree.seekFirst()
+}
+
+func (tree *Tree) seekFirst() (*Enumerator, error) {
+	return tree.newEnumerator(func(index *btree.BTree[any, any], file *os.File) (*btree.Enumerator[any, any], error) {
+		return index.SeekFirst(file)
+	})
+}
+
+func (tree *Tree) Seek(key any) (*Enumerator, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return tree.seek(key)
+}
+
+func (tree *Tree) seek(key any) (*Enumerator, error) {
+	if err := checkQueryKey(key); err != nil {
+		return nil, err
+	}
+	return tree.newEnumerator(func(index *btree.BTree[any, any], file *os.File) (*btree.Enumerator[any, any], error) {
+		return index.Seek(key, file)
+	})
+}
+
+func (tree *Tree) SeekLast() (*Enumerator, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	return tree.seekLast()
+}
+
+func (tree *Tree) seekLast() (*Enumerator, error) {
+	return tree.newEnumerator(func(index *btree.BTree[any, any], file *os.File) (*btree.Enumerator[any, any], error) {
+		return index.SeekLast(file)
+	})
+}
+
+// seekAfter returns an enumerator walking backwards from the last key starting with key.
+func (tree *Tree) seekAfter(key any) (*Enumerator, error) {
+	if err := checkQueryKey(key); err != nil {
+		return nil, err
+	}
+	return tree.newEnumerator(func(index *btree.BTree[any, any], file *os.File) (*btree.Enumerator[any, any], error) {
+		return index.SeekAfter(key, file)
+	})
+}
+
+// newEnumerator returns an enumerator positioned by seek on a snapshot of the index, keeping the index file it reads
+// open until the enumerator is closed. Callers must hold the tree lock.
+func (tree *Tree) newEnumerator(seek func(index *btree.BTree[any, any], file *os.File) (*btree.Enumerator[any, any], error)) (*Enumerator, error) {
+	file, err := tree.openFile()
+
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := tree.pool.Snapshot()
+	index := tree.index.AtSnapshot(snapshot)
+	btreeEnumerator, err := seek(index, file)
 	if err != nil {
-		panic(err)
+		snapshot.Release()
+		return nil, err
 	}
+	return &Enumerator{btreeEnumerator: btreeEnumerator, handle: tree.handle.acquire(), tree: tree, index: index, snapshot: snapshot}, nil
+}
 
-	return &Enumerator{btreeEnumerator: tree.index.SeekLast(file), file: file}
+// PageStats reports how the pages of the index and its sub indexes were served by the page pool they share, which holds
+// up to PagePoolSize decoded pages and evicts the least recently used.
+func (tree *Tree) PageStats() btree.PoolStats {
+	return tree.pool.Stats()
 }
 
 func (tree *Tree) Count() int {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
 	return tree.index.Count
 }
 
 // In Gets values from index of keys passed in array. when passed in sorted order
-func (tree *Tree) In(keys []any) map[any]*dbmodels.Page {
+func (tree *Tree) In(keys []any) (map[any]*dbmodels.Page, error) {
 	if len(keys) == 0 {
-		return map[any]*dbmodels.Page{}
+		return map[any]*dbmodels.Page{}, nil
 	}
 
 	tree.lock.RLock()
@@ -214,53 +713,80 @@ func (tree *Tree) In(keys []any) map[any]*dbmodels.Page {
 	var result = map[any]*dbmodels.Page{} //Result container
 
 	for _, key := range keys {
-		if val, exists := tree.Get(key); exists {
+		val, exists, err := tree.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
 			for primaryKey, location := range *val {
 				result[primaryKey] = location
 			}
 		}
 	}
 
-	return result
+	return result, nil
 }
 
-// In Gets values from index of keys passed in array. when passed in sorted order
-func (tree *Tree) InSorted(keys []any, limit int, seek int) []*dbmodels.PrimaryKeyPageTuple {
+// InSorted returns the rows of keys in the order of the index whatever the order of keys and, within a key, by
+// primary key. At most limit rows are returned, after the row of cursor, along with the cursor of the next page. A
+// limit below 1 returns ErrInvalidLimit.
+func (tree *Tree) InSorted(keys []any, limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+	pager, err := tree.newPager(limit, cursor, false)
+	if err != nil {
+		return nil, "", err
+	}
 	if len(keys) == 0 {
-		return []*dbmodels.PrimaryKeyPageTuple{}
+		return []*dbmodels.PrimaryKeyPageTuple{}, "", nil
 	}
 
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
 
+	keys, err = tree.sortedKeys(keys)
+	if err != nil {
+		return nil, "", err
+	}
 	result := make([]*dbmodels.PrimaryKeyPageTuple, 0) //Result container
-	var i int
-	var resultCount = 0
 
 inIndexWalk:
 	for _, key := range keys {
-		if val, exists := tree.Get(key); exists {
-			for primaryKey, location := range *val {
-				if resultCount < seek {
-					resultCount++
-				} else {
-					if i < limit {
-						result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: primaryKey, Page: location, Key: key})
-						i++
-					} else {
-						break inIndexWalk
-					}
+		val, exists, err := tree.getResultSet(key)
+		if err != nil {
+			return nil, "", err
+		}
+		if exists {
+			rows, err := pager.rowsOf(key, val)
+			if err != nil {
+				return nil, "", err
+			}
+			for _, row := range rows {
+				if !pager.add(key, row.PrimaryKey) {
+					break inIndexWalk
 				}
+				result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: row.PrimaryKey, Page: row.Page, Key: key})
 			}
 		}
 	}
 
-	return result
+	next, err := pager.next()
+	return result, next, err
 }
 
-func (tree *Tree) InKeysOf(keys []any) []*dbmodels.Page {
+// sortedKeys returns a copy of keys in the order of the index, e.g. from the largest key of a descending index.
+func (tree *Tree) sortedKeys(keys []any) ([]any, error) {
+	for _, key := range keys {
+		if err := checkQueryKey(key); err != nil {
+			return nil, err
+		}
+	}
+	sorted := slices.Clone(keys)
+	slices.SortStableFunc(sorted, tree.index.Compare)
+	return sorted, nil
+}
+
+func (tree *Tree) InKeysOf(keys []any) ([]*dbmodels.Page, error) {
 	if len(keys) == 0 {
-		return []*dbmodels.Page{}
+		return []*dbmodels.Page{}, nil
 	}
 
 	tree.lock.RLock()
@@ -269,7 +795,10 @@ func (tree *Tree) InKeysOf(keys []any) []*dbmodels.Page {
 	var result []*dbmodels.Page //Result container
 
 	for _, key := range keys {
-		val, exists := tree.Get(key)
+		val, exists, err := tree.get(key)
+		if err != nil {
+			return nil, err
+		}
 		if exists {
 			for _, location := range *val {
 				result = append(result, location)
@@ -277,14 +806,17 @@ func (tree *Tree) InKeysOf(keys []any) []*dbmodels.Page {
 		}
 	}
 
-	return result
+	return result, nil
 }
 
 // Gets values from index of keys passed in array. when passed in sorted order
-func (tree *Tree) InAndRelevantKeys(keys []any, relevantKeys map[any]float64) map[any]*dbmodels.Page {
+func (tree *Tree) InAndRelevantKeys(keys []any, relevantKeys map[any]float64) (map[any]*dbmodels.Page, error) {
 	if len(relevantKeys) == 0 {
 		return tree.In(keys)
 	}
+	if err := checkPrimaryKeys(relevantKeys); err != nil {
+		return nil, err
+	}
 
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
@@ -292,7 +824,10 @@ func (tree *Tree) InAndRelevantKeys(keys []any, relevantKeys map[any]float64) ma
 	var result = map[any]*dbmodels.Page{} //Result container
 
 	for _, key := range keys {
-		val, exists := tree.Get(key)
+		val, exists, err := tree.get(key)
+		if err != nil {
+			return nil, err
+		}
 		if exists {
 			for primaryKey := range relevantKeys {
 				if location, existsInKeys := (*val)[primaryKey]; existsInKeys {
@@ -301,256 +836,367 @@ func (tree *Tree) InAndRelevantKeys(keys []any, relevantKeys map[any]float64) ma
 			}
 		}
 	}
-	return result
+	return result, nil
 }
 
-// Gets values from index of keys passed in array. when passed in sorted order
-func (tree *Tree) InAndRelevantKeysSorted(keys []any, relevantKeys map[any]float64, limit int, seek int) []*dbmodels.PrimaryKeyPageTuple {
+// InAndRelevantKeysSorted returns the rows of InAndRelevantKeys in the order of InSorted, at most limit rows after
+// the row of cursor along with the cursor of the next page. A limit below 1 returns ErrInvalidLimit.
+func (tree *Tree) InAndRelevantKeysSorted(keys []any, relevantKeys map[any]float64, limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
 	if len(relevantKeys) == 0 {
-		return tree.InSorted(keys, limit, seek)
+		return tree.InSorted(keys, limit, cursor)
+	}
+	pager, err := tree.newPager(limit, cursor, false)
+	if err != nil {
+		return nil, "", err
 	}
 
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
 
+	keys, err = tree.sortedKeys(keys)
+	if err != nil {
+		return nil, "", err
+	}
+	primaryKeys, err := sortedPrimaryKeys(relevantKeys)
+	if err != nil {
+		return nil, "", err
+	}
 	result := make([]*dbmodels.PrimaryKeyPageTuple, 0) //Result container
-	var i int
-	var resultCount = 0
 
 inAndRelevantKeyWalk:
 	for _, key := range keys {
-		val, exists := tree.Get(key)
+		val, exists, err := tree.getResultSet(key)
+		if err != nil {
+			return nil, "", err
+		}
 		if exists {
-			for primaryKey := range relevantKeys {
-				if location, existsInKeys := (*val)[primaryKey]; existsInKeys {
-					if resultCount < seek {
-						resultCount++
-					} else {
-						if i < limit {
-							result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: primaryKey, Page: location, Key: key})
-							i++
-						} else {
-							break inAndRelevantKeyWalk
-						}
+			for _, primaryKey := range primaryKeys {
+				if pager.skip(key, primaryKey) {
+					continue
+				}
+				location, existsInKeys, err := val.Has(primaryKey)
+				if err != nil {
+					return nil, "", err
+				}
+				if existsInKeys {
+					if !pager.add(key, primaryKey) {
+						break inAndRelevantKeyWalk
 					}
+					result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: primaryKey, Page: location, Key: key})
 				}
 			}
 		}
 	}
-	return result
+
+	next, err := pager.next()
+	return result, next, err
 }
 
-func (tree *Tree) Range(lower any, upper any) map[any]*dbmodels.Page {
+// Range returns the rows of the keys of keyRange.
+func (tree *Tree) Range(keyRange KeyRange) (map[any]*dbmodels.Page, error) {
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
 
-	// If lower is greater then return empty
-	if utils.Compare(lower, upper) == 1 {
-		return map[any]*dbmodels.Page{}
+	e, err := tree.seekRange(keyRange)
+	if err != nil {
+		return nil, err
 	}
-
-	// If lower is out of bounds then return empty array
-	e := tree.Seek(lower)
+	defer e.Close()
 
 	var result = map[any]*dbmodels.Page{} //Result container
 	for e.HasNext() {
-		key, val := e.Next()
-		if utils.Compare(key, upper) <= 0 {
-			for primaryKey, location := range val.ToIterable() {
-				result[primaryKey] = location
-			}
-		} else {
-			break
+		_, val, err := e.Next()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := val.ToIterable()
+		if err != nil {
+			return nil, err
+		}
+		for primaryKey, location := range rows {
+			result[primaryKey] = location
 		}
 	}
 
-	e.Close()
-	return result
+	return result, nil
 }
 
-func (tree *Tree) RangeSorted(lower any, upper any, limit int, seek int) []*dbmodels.PrimaryKeyPageTuple {
+// RangeSorted returns the rows of the keys of keyRange in the order of the index and, within a key, by primary key.
+// At most limit rows are returned, after the row of cursor, along with the cursor of the next page. A limit below 1
+// returns ErrInvalidLimit.
+func (tree *Tree) RangeSorted(keyRange KeyRange, limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+	pager, err := tree.newPager(limit, cursor, false)
+	if err != nil {
+		return nil, "", err
+	}
+
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
 
-	// If lower is greater then return empty
-	if utils.Compare(lower, upper) == 1 {
-		return []*dbmodels.PrimaryKeyPageTuple{}
+	resumed, err := pager.resumeRange(keyRange)
+	if err != nil {
+		return nil, "", err
 	}
+	e, err := tree.seekRange(resumed)
+	if err != nil {
+		return nil, "", err
+	}
+	defer e.Close()
 
-	// If lower is out of bounds then return empty array
-	e := tree.Seek(lower)
-
-	var i int
-	var resultCount = 0
 	var result = make([]*dbmodels.PrimaryKeyPageTuple, 0) //Result container
 
 rangeSortedIndexWalk:
 	for e.HasNext() {
-		key, val := e.Next()
-		if utils.Compare(key, upper) <= 0 {
-			for primaryKey, location := range val.ToIterable() {
-				if resultCount < seek {
-					resultCount++
-				} else {
-					if i < limit {
-						result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: primaryKey, Page: location, Key: key})
-						i++
-					} else {
-						break rangeSortedIndexWalk
-					}
-				}
+		key, val, err := e.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		rows, err := pager.rowsOf(*key, val)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			if !pager.add(*key, row.PrimaryKey) {
+				break rangeSortedIndexWalk
 			}
-		} else {
-			break
+			result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: row.PrimaryKey, Page: row.Page, Key: key})
 		}
 	}
 
-	e.Close()
-	return result
+	next, err := pager.next()
+	return result, next, err
 }
 
-func (tree *Tree) RangeAndRelevantKeys(lower any, upper any, relevantKeys map[any]float64) map[any]*dbmodels.Page {
+// RangeReverseSorted returns the rows of RangeSorted from the last one, walking the index backwards from the upper
+// bound of keyRange. It seeks straight to the upper bound, so the latest rows of a range, e.g. between two
+// timestamps, are read without reading the rest.
+func (tree *Tree) RangeReverseSorted(keyRange KeyRange, limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+	pager, err := tree.newPager(limit, cursor, true)
+	if err != nil {
+		return nil, "", err
+	}
+
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
 
-	if len(relevantKeys) == 0 {
-		return tree.Range(lower, upper)
+	resumed, err := pager.resumeRange(keyRange)
+	if err != nil {
+		return nil, "", err
 	}
+	e, err := tree.seekRangeEnd(resumed)
+	if err != nil {
+		return nil, "", err
+	}
+	defer e.Close()
+
+	var result = make([]*dbmodels.PrimaryKeyPageTuple, 0) //Result container
 
-	// If lower is greater then return empty
-	if utils.Compare(lower, upper) == 1 {
-		return map[any]*dbmodels.Page{}
+rangeReverseSortedIndexWalk:
+	for e.HasPrevious() {
+		key, val, err := e.Previous()
+		if err != nil {
+			return nil, "", err
+		}
+		if !afterLower(tree.index, keyRange, *key) {
+			break
+		}
+		rows, err := pager.rowsOf(*key, val)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			if !pager.add(*key, row.PrimaryKey) {
+				break rangeReverseSortedIndexWalk
+			}
+			result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: row.PrimaryKey, Page: row.Page, Key: key})
+		}
 	}
 
-	// If lower is out of bounds then return empty array
-	e := tree.Seek(lower)
+	next, err := pager.next()
+	return result, next, err
+}
+
+// RangeAndRelevantKeys returns the rows of the keys of keyRange whose primary key is one of relevantKeys.
+func (tree *Tree) RangeAndRelevantKeys(keyRange KeyRange, relevantKeys map[any]float64) (map[any]*dbmodels.Page, error) {
+	if len(relevantKeys) == 0 {
+		return tree.Range(keyRange)
+	}
+	if err := checkPrimaryKeys(relevantKeys); err != nil {
+		return nil, err
+	}
+
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	e, err := tree.seekRange(keyRange)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Close()
 
 	var result = map[any]*dbmodels.Page{} //Result container
 	for e.HasNext() {
-		key, val := e.Next()
-		if utils.Compare(key, upper) <= 0 {
-			for primaryKey := range relevantKeys {
-				if location, existsInKeys := val.Has(primaryKey); existsInKeys {
-					result[primaryKey] = location
-				}
+		_, val, err := e.Next()
+		if err != nil {
+			return nil, err
+		}
+		for primaryKey := range relevantKeys {
+			location, existsInKeys, err := val.Has(primaryKey)
+			if err != nil {
+				return nil, err
+			}
+			if existsInKeys {
+				result[primaryKey] = location
 			}
-		} else {
-			break
 		}
 	}
 
-	e.Close()
-	return result
+	return result, nil
 }
 
-func (tree *Tree) RangeAndRelevantKeysSorted(lower any, upper any, relevantKeys map[any]float64, limit int, seek int) []*dbmodels.PrimaryKeyPageTuple {
+// RangeAndRelevantKeysSorted returns the rows of RangeAndRelevantKeys in the order of RangeSorted, at most limit rows
+// after the row of cursor along with the cursor of the next page. A limit below 1 returns ErrInvalidLimit.
+func (tree *Tree) RangeAndRelevantKeysSorted(keyRange KeyRange, relevantKeys map[any]float64, limit int, cursor Cursor) ([]*dbmodels.PrimaryKeyPageTuple, Cursor, error) {
+	if len(relevantKeys) == 0 {
+		return tree.RangeSorted(keyRange, limit, cursor)
+	}
+	pager, err := tree.newPager(limit, cursor, false)
+	if err != nil {
+		return nil, "", err
+	}
+
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
 
-	if len(relevantKeys) == 0 {
-		return tree.RangeSorted(lower, upper, limit, seek)
+	resumed, err := pager.resumeRange(keyRange)
+	if err != nil {
+		return nil, "", err
 	}
-
-	// If lower is greater then return empty
-	if utils.Compare(lower, upper) == 1 {
-		return []*dbmodels.PrimaryKeyPageTuple{}
+	e, err := tree.seekRange(resumed)
+	if err != nil {
+		return nil, "", err
 	}
+	defer e.Close()
 
-	// If lower is out of bounds then return empty array
-	e := tree.Seek(lower)
-
-	var i int
-	var resultCount = 0
+	primaryKeys, err := sortedPrimaryKeys(relevantKeys)
+	if err != nil {
+		return nil, "", err
+	}
 	var result = make([]*dbmodels.PrimaryKeyPageTuple, 0) //Result container
 
 rangeAndRelevantKeyWalk:
 	for e.HasNext() {
-		key, val := e.Next()
-		if utils.Compare(key, upper) <= 0 {
-			for primaryKey := range relevantKeys {
-				if location, existsInKeys := val.Has(primaryKey); existsInKeys {
-					if resultCount < seek {
-						resultCount++
-					} else {
-						if i < limit {
-							result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: primaryKey, Page: location, Key: key})
-							i++
-						} else {
-							break rangeAndRelevantKeyWalk
-						}
-					}
+		key, val, err := e.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, primaryKey := range primaryKeys {
+			if pager.skip(*key, primaryKey) {
+				continue
+			}
+			location, existsInKeys, err := val.Has(primaryKey)
+			if err != nil {
+				return nil, "", err
+			}
+			if existsInKeys {
+				if !pager.add(*key, primaryKey) {
+					break rangeAndRelevantKeyWalk
 				}
+				result = append(result, &dbmodels.PrimaryKeyPageTuple{PrimaryKey: primaryKey, Page: location, Key: key})
 			}
-		} else {
-			break
 		}
 	}
 
-	e.Close()
-	return result
+	next, err := pager.next()
+	return result, next, err
 }
 
-func (tree *Tree) All(limit, seek int) []*dbmodels.SortParamLocation {
+// All returns the rows of the index grouped by key in the order of the index and, within a key, by primary key. At
+// most limit rows are returned, after the row of cursor, along with the cursor of the next page. A limit below 1
+// returns ErrInvalidLimit.
+func (tree *Tree) All(limit int, cursor Cursor) ([]*dbmodels.SortParamLocation, Cursor, error) {
+	pager, err := tree.newPager(limit, cursor, false)
+	if err != nil {
+		return nil, "", err
+	}
+
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
-	e := tree.SeekFirst()
+	resumed, err := pager.resumeRange(KeyRange{})
+	if err != nil {
+		return nil, "", err
+	}
+	e, err := tree.seekRange(resumed)
+	if err != nil {
+		return nil, "", err
+	}
+	defer e.Close()
 
-	var i int
-	var resultCount = 0
 	result := make([]*dbmodels.SortParamLocation, 0) //Result container
 
 indexWalk:
 	for e.HasNext() {
-		key, val := e.Next()
-		for _, location := range val.ToIterable() {
-			if resultCount < seek {
-				resultCount++
-			} else {
-				if i < limit {
-					result = tree.appendResultSorted(result, location, *key)
-					i++
-				} else {
-					break indexWalk
-				}
+		key, val, err := e.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		rows, err := pager.rowsOf(*key, val)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			if !pager.add(*key, row.PrimaryKey) {
+				break indexWalk
 			}
-
+			result = tree.appendResultSorted(result, row.Page, *key)
 		}
 	}
 
-	e.Close()
-	return result
+	next, err := pager.next()
+	return result, next, err
 }
 
-func (tree *Tree) AllReverse(limit, seek int) []*dbmodels.SortParamLocation {
+// AllReverse returns the rows of All from the last one.
+func (tree *Tree) AllReverse(limit int, cursor Cursor) ([]*dbmodels.SortParamLocation, Cursor, error) {
+	pager, err := tree.newPager(limit, cursor, true)
+	if err != nil {
+		return nil, "", err
+	}
+
 	tree.lock.RLock()
 	defer tree.lock.RUnlock()
-	e := tree.SeekLast()
-
-	var i int
-	var resultCount = 0
+	resumed, err := pager.resumeRange(KeyRange{})
+	if err != nil {
+		return nil, "", err
+	}
+	e, err := tree.seekRangeEnd(resumed)
+	if err != nil {
+		return nil, "", err
+	}
+	defer e.Close()
 
 	result := make([]*dbmodels.SortParamLocation, 0) //Result container
 
 indexWalk:
 	for e.HasPrevious() {
-		key, val := e.Previous()
-		for _, location := range val.ToIterable() {
-			if resultCount < seek {
-				resultCount++
-			} else {
-				if i < limit {
-					result = tree.appendResultSorted(result, location, *key)
-					i++
-				} else {
-					break indexWalk
-				}
+		key, val, err := e.Previous()
+		if err != nil {
+			return nil, "", err
+		}
+		rows, err := pager.rowsOf(*key, val)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			if !pager.add(*key, row.PrimaryKey) {
+				break indexWalk
 			}
-
+			result = tree.appendResultSorted(result, row.Page, *key)
 		}
 	}
 
-	e.Close()
-	return result
+	next, err := pager.next()
+	return result, next, err
 }
 
 func (tree *Tree) appendResultSorted(result []*dbmodels.SortParamLocation, row *dbmodels.Page, key any) []*dbmodels.SortParamLocation {
@@ -560,7 +1206,7 @@ func (tree *Tree) appendResultSorted(result []*dbmodels.SortParamLocation, row *
 			Locations: []*dbmodels.Page{row},
 		})
 	} else {
-		if utils.Compare(result[len(result)-1].SortParam, key) == 0 {
+		if tree.index.Compare(result[len(result)-1].SortParam, key) == 0 {
 			result[len(result)-1].Locations = append(result[len(result)-1].Locations, row)
 		} else {
 			result = append(result, &dbmodels.SortParamLocation{
@@ -0,0 +1,503 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// openTestTree opens an index over a fresh index directory, closed when the test ends.
+func openTestTree(t *testing.T, options Options) *Tree {
+	t.Helper()
+	IndexDirectory = t.TempDir()
+	tree, err := New("collection", "field", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree
+}
+
+// rowOf returns the page of the row of primaryKey under key, nil when the key does not hold it.
+func rowOf(t *testing.T, tree *Tree, primaryKey any, key any) *dbmodels.Page {
+	t.Helper()
+	rows, found, err := tree.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		return nil
+	}
+	return (*rows)[primaryKey]
+}
+
+// subIndexFileOf returns the sub index file of key in the index opened by openTestTree.
+func subIndexFileOf(t *testing.T, key any) string {
+	t.Helper()
+	indexName, err := SubIndexFile("collection", "field", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexName
+}
+
+func TestDeleteRemovesRowsKeysAndSubIndexFiles(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 3})
+	for primaryKey := 0; primaryKey < 5; primaryKey++ {
+		if err := tree.Put(primaryKey, "sub", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for primaryKey := 0; primaryKey < 2; primaryKey++ {
+		if err := tree.Put(primaryKey, "inline", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subIndexFile := subIndexFileOf(t, "sub")
+	if _, err := os.Stat(subIndexFile); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		primaryKey any
+		key        any
+		deleted    bool
+	}{
+		{0, "inline", true},
+		{0, "inline", false},
+		{0, "missing", false},
+		{7, "sub", false},
+		{1, "inline", true},
+		{0, "sub", true},
+		{1, "sub", true},
+		{2, "sub", true},
+		{3, "sub", true},
+	} {
+		deleted, err := tree.Delete(c.primaryKey, c.key)
+		if err != nil || deleted != c.deleted {
+			t.Fatalf("Delete(%v, %v) returned %v %v", c.primaryKey, c.key, deleted, err)
+		}
+		if page := rowOf(t, tree, c.primaryKey, c.key); page != nil {
+			t.Fatalf("row %v left under %v", c.primaryKey, c.key)
+		}
+	}
+	if _, found, err := tree.Get("inline"); err != nil || found {
+		t.Fatal("emptied key left in the index", err)
+	}
+	if page := rowOf(t, tree, 4, "sub"); page == nil || tree.Count() != 1 {
+		t.Fatal("last row of the sub tree lost", page, tree.Count())
+	}
+
+	if deleted, err := tree.Delete(4, "sub"); err != nil || !deleted {
+		t.Fatal(deleted, err)
+	}
+	if _, found, err := tree.Get("sub"); err != nil || found || tree.Count() != 0 {
+		t.Fatal("emptied sub tree left in the index", err, tree.Count())
+	}
+	if _, err := os.Stat(subIndexFile); !os.IsNotExist(err) {
+		t.Fatalf("sub index file of an emptied key left: %v", err)
+	}
+}
+
+func TestUpdateMovesRow(t *testing.T) {
+	tree := openTestTree(t, Options{})
+	if err := tree.Put(1, "a", &dbmodels.Page{DataOffset: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := tree.Update(1, "a", "b", &dbmodels.Page{DataOffset: 2})
+	if err != nil || !updated {
+		t.Fatal(updated, err)
+	}
+	if page := rowOf(t, tree, 1, "a"); page != nil {
+		t.Fatal("row left under the old key")
+	}
+	if page := rowOf(t, tree, 1, "b"); page == nil || page.DataOffset != 2 {
+		t.Fatal("row missing under the new key", page)
+	}
+
+	updated, err = tree.Update(1, "a", "c", &dbmodels.Page{})
+	if err != nil || updated {
+		t.Fatal("missing row updated", updated, err)
+	}
+}
+
+func TestUpdateFailureKeepsRow(t *testing.T) {
+	tree := openTestTree(t, Options{Unique: true})
+	if err := tree.Put(1, "a", &dbmodels.Page{DataOffset: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put(2, "b", &dbmodels.Page{DataOffset: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := tree.Update(1, "a", "b", &dbmodels.Page{})
+	if !errors.Is(err, ErrDuplicateKey) || updated {
+		t.Fatal(updated, err)
+	}
+	if page := rowOf(t, tree, 1, "a"); page == nil || page.DataOffset != 1 {
+		t.Fatal("row lost under the old key", page)
+	}
+	if page := rowOf(t, tree, 2, "b"); page == nil || page.DataOffset != 2 {
+		t.Fatal("row of the new key changed", page)
+	}
+}
+
+func TestCompactKeepsRows(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 4})
+	for primaryKey := 0; primaryKey < 600; primaryKey++ {
+		if err := tree.Put(primaryKey, primaryKey%30, &dbmodels.Page{DataOffset: int64(primaryKey)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for primaryKey := 0; primaryKey < 600; primaryKey += 3 {
+		if _, err := tree.Delete(primaryKey, primaryKey%30); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Count reads the index while Compact swaps it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tree.Count()
+		}
+	}()
+	if err := tree.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for primaryKey := 0; primaryKey < 600; primaryKey++ {
+		page := rowOf(t, tree, primaryKey, primaryKey%30)
+		if deleted := primaryKey%3 == 0; deleted != (page == nil) {
+			t.Fatalf("row %d: page %v after compaction", primaryKey, page)
+		}
+		if page != nil && page.DataOffset != int64(primaryKey) {
+			t.Fatalf("row %d holds %d", primaryKey, page.DataOffset)
+		}
+	}
+	// Keys divisible by 3 lost all their rows
+	if tree.Count() != 20 {
+		t.Fatal("count", tree.Count())
+	}
+}
+
+func TestUnsupportedKeysAreRejected(t *testing.T) {
+	tree := openTestTree(t, Options{})
+	if err := tree.Put(1, "a", &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Put(2, [2]int{1, 2}, &dbmodels.Page{}); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatal("key", err)
+	}
+	if err := tree.Put(struct{ id int }{2}, "a", &dbmodels.Page{}); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatal("primary key", err)
+	}
+	if _, err := tree.Update(1, "a", CompositeKey{"b", &dbmodels.Page{}}, &dbmodels.Page{}); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatal("composite key value", err)
+	}
+	if page := rowOf(t, tree, 1, "a"); page == nil {
+		t.Fatal("row lost by a rejected update")
+	}
+}
+
+func TestPutHonoursSubTreeThreshold(t *testing.T) {
+	const threshold = 5
+	tree := openTestTree(t, Options{SubTreeThreshold: threshold})
+	subIndexFile := subIndexFileOf(t, "key")
+
+	for primaryKey := 0; primaryKey < threshold-1; primaryKey++ {
+		if err := tree.Put(primaryKey, "key", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Putting a row again replaces it without adding one
+	if err := tree.Put(0, "key", &dbmodels.Page{DataOffset: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(subIndexFile); !os.IsNotExist(err) {
+		t.Fatalf("sub index file below the threshold: %v", err)
+	}
+
+	if err := tree.Put(threshold, "key", &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(subIndexFile); err != nil {
+		t.Fatalf("no sub index file at the threshold: %v", err)
+	}
+	rows, _, err := tree.Get("key")
+	if err != nil || len(*rows) != threshold || (*rows)[0].DataOffset != 1 {
+		t.Fatal(rows, err)
+	}
+}
+
+func TestSubIndexFilesKeepKeysApart(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 2})
+	long := strings.Repeat("k", 200)
+	keys := []any{[]byte("a"), "[97]", "a", "A", "a/b", int64(1), 1.5, true, long, long + "l"}
+
+	files := map[string]any{}
+	for i, key := range keys {
+		for primaryKey := 0; primaryKey < 2; primaryKey++ {
+			if err := tree.Put(primaryKey, key, &dbmodels.Page{DataOffset: int64(i)}); err != nil {
+				t.Fatal(key, err)
+			}
+		}
+		subIndexFile := subIndexFileOf(t, key)
+		if other, ok := files[strings.ToLower(subIndexFile)]; ok {
+			t.Fatalf("%#v and %#v share the sub index file %s", key, other, subIndexFile)
+		}
+		files[strings.ToLower(subIndexFile)] = key
+		if filepath.Dir(subIndexFile) != IndexDirectory || len(filepath.Base(subIndexFile)) > 255 {
+			t.Fatalf("sub index file %s of %#v", subIndexFile, key)
+		}
+		if _, err := os.Stat(subIndexFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, key := range keys {
+		rows, found, err := tree.Get(key)
+		if err != nil || !found || len(*rows) != 2 {
+			t.Fatalf("%#v: %v %v", key, rows, err)
+		}
+		for _, page := range *rows {
+			if page.DataOffset != int64(i) {
+				t.Fatalf("%#v holds the row of %#v", key, keys[page.DataOffset])
+			}
+		}
+	}
+}
+
+func TestReadsAndDeletesRejectUnsupportedKeys(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 2})
+	for primaryKey := 0; primaryKey < 3; primaryKey++ {
+		if err := tree.Put(primaryKey, "a", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Put(1, "b", &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	unsupported := struct{}{}
+	relevantKeys := map[any]float64{1: 1, unsupported: 1}
+
+	calls := map[string]func() error{
+		"Get": func() error { _, _, err := tree.Get(unsupported); return err },
+		"Get composite": func() error {
+			_, _, err := tree.Get(CompositeKey{"a", unsupported})
+			return err
+		},
+		"Delete key":         func() error { _, err := tree.Delete(1, unsupported); return err },
+		"Delete primary key": func() error { _, err := tree.Delete(unsupported, "a"); return err },
+		"Seek":               func() error { _, err := tree.Seek(unsupported); return err },
+		"SeekRange lower":    func() error { _, err := tree.SeekRange(Between(unsupported, "b")); return err },
+		"SeekRange upper": func() error {
+			_, err := tree.SeekRange(KeyRange{}.Below(unsupported))
+			return err
+		},
+		"In":                func() error { _, err := tree.In([]any{"a", unsupported}); return err },
+		"InSorted":          func() error { _, _, err := tree.InSorted([]any{"a", unsupported}, 10, ""); return err },
+		"InKeysOf":          func() error { _, err := tree.InKeysOf([]any{unsupported}); return err },
+		"InAndRelevantKeys": func() error { _, err := tree.InAndRelevantKeys([]any{"a"}, relevantKeys); return err },
+		"InAndRelevantKeys key": func() error {
+			_, err := tree.InAndRelevantKeys([]any{unsupported}, map[any]float64{1: 1})
+			return err
+		},
+		"InAndRelevantKeysSorted": func() error {
+			_, _, err := tree.InAndRelevantKeysSorted([]any{"a"}, relevantKeys, 10, "")
+			return err
+		},
+		"Range":              func() error { _, err := tree.Range(Between(unsupported, 3)); return err },
+		"RangeSorted":        func() error { _, _, err := tree.RangeSorted(KeyRange{}.AtMost(unsupported), 10, ""); return err },
+		"RangeReverseSorted": func() error { _, _, err := tree.RangeReverseSorted(KeyRange{}.Above(unsupported), 10, ""); return err },
+		"RangeAndRelevantKeys": func() error {
+			_, err := tree.RangeAndRelevantKeys(KeyRange{}, relevantKeys)
+			return err
+		},
+		"RangeAndRelevantKeysSorted": func() error {
+			_, _, err := tree.RangeAndRelevantKeysSorted(KeyRange{}, relevantKeys, 10, "")
+			return err
+		},
+		"Scan": func() error {
+			var err error
+			for range tree.Scan(Between(unsupported, 3), &err) {
+			}
+			return err
+		},
+	}
+	for name, resultSet := range map[string]func() (*ResultSet, error){
+		"inline rows": func() (*ResultSet, error) { resultSet, _, err := tree.getResultSet("b"); return resultSet, err },
+		"sub tree":    func() (*ResultSet, error) { resultSet, _, err := tree.getResultSet("a"); return resultSet, err },
+	} {
+		rows, err := resultSet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls["ResultSet.Has of "+name] = func() error { _, _, err := rows.Has(unsupported); return err }
+		calls["ResultSet.Seek of "+name] = func() error { _, err := rows.Seek(unsupported); return err }
+	}
+
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrUnsupportedKey) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if tree.Count() != 2 {
+		t.Fatal("count", tree.Count())
+	}
+}
+
+func TestClosedTreeReturnsErrClosed(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 2})
+	for primaryKey := 0; primaryKey < 3; primaryKey++ {
+		if err := tree.Put(primaryKey, "a", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := tree.SeekFirst()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err = tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An enumerator opened before Close keeps reading, also the sub index file of its key
+	key, resultSet, err := e.Next()
+	if err != nil || (*key).(string) != "a" {
+		t.Fatal(key, err)
+	}
+	rows := 0
+	for range resultSet.Rows(&err) {
+		rows++
+	}
+	if err != nil || rows != 3 {
+		t.Fatal(rows, "rows read after Close", err)
+	}
+
+	calls := map[string]func() error{
+		"Close":   tree.Close,
+		"Put":     func() error { return tree.Put(3, "a", &dbmodels.Page{}) },
+		"Delete":  func() error { _, err := tree.Delete(0, "a"); return err },
+		"Update":  func() error { _, err := tree.Update(0, "a", "b", &dbmodels.Page{}); return err },
+		"Compact": tree.Compact,
+		"BulkLoad": func() error {
+			return tree.BulkLoad(&rowSlice{rows: []testRow{{"b", 1, &dbmodels.Page{}}}}, 1)
+		},
+		"Get":       func() error { _, _, err := tree.Get("a"); return err },
+		"SeekFirst": func() error { _, err := tree.SeekFirst(); return err },
+		"SeekLast":  func() error { _, err := tree.SeekLast(); return err },
+		"Seek":      func() error { _, err := tree.Seek("a"); return err },
+		"SeekRange": func() error { _, err := tree.SeekRange(KeyRange{}); return err },
+		"Scan": func() error {
+			var err error
+			for range tree.Scan(KeyRange{}, &err) {
+			}
+			return err
+		},
+		"In":          func() error { _, err := tree.In([]any{"a"}); return err },
+		"InSorted":    func() error { _, _, err := tree.InSorted([]any{"a"}, 10, ""); return err },
+		"InKeysOf":    func() error { _, err := tree.InKeysOf([]any{"a"}); return err },
+		"Range":       func() error { _, err := tree.Range(KeyRange{}); return err },
+		"RangeSorted": func() error { _, _, err := tree.RangeSorted(KeyRange{}, 10, ""); return err },
+		"RangeReverseSorted": func() error {
+			_, _, err := tree.RangeReverseSorted(KeyRange{}, 10, "")
+			return err
+		},
+		"All":        func() error { _, _, err := tree.All(10, ""); return err },
+		"AllReverse": func() error { _, _, err := tree.AllReverse(10, ""); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close: %v", name, err)
+		}
+	}
+}
+
+func TestPageStatsCountSubTreeReads(t *testing.T) {
+	tree := openTestTree(t, Options{SubTreeThreshold: 2})
+	for primaryKey := 0; primaryKey < 3; primaryKey++ {
+		if err := tree.Put(primaryKey, "a", &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := New("collection", "field", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// The index and the sub tree of "a" are read through the pool of the tree, a page each
+	if rows, _, err := tree.Get("a"); err != nil || len(*rows) != 3 {
+		t.Fatal(rows, err)
+	}
+	if stats := tree.PageStats(); stats.Misses != 2 || stats.Hits != 0 {
+		t.Fatalf("first read: %+v", stats)
+	}
+	if _, _, err := tree.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := tree.PageStats(); stats.Misses != 2 || stats.Hits != 2 {
+		t.Fatalf("second read: %+v", stats)
+	}
+}
+
+func TestUniqueIndexRejectsDuplicateKeys(t *testing.T) {
+	tree := openTestTree(t, Options{Unique: true})
+	if err := tree.Put(1, "a", &dbmodels.Page{DataOffset: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// Putting the same row again replaces its page
+	if err := tree.Put(1, "a", &dbmodels.Page{DataOffset: 2}); err != nil {
+		t.Fatal("row put again:", err)
+	}
+	if err := tree.Put(2, "a", &dbmodels.Page{}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatal("second primary key:", err)
+	}
+	if rows, _, err := tree.Get("a"); err != nil || len(*rows) != 1 || (*rows)[1].DataOffset != 2 {
+		t.Fatal("rows of a rejected key", rows, err)
+	}
+
+	if _, err := tree.Delete(1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put(2, "a", &dbmodels.Page{}); err != nil {
+		t.Fatal("key freed by Delete:", err)
+	}
+
+	// The index stays unique when opened without the option
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := New("collection", "field", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err = tree.Put(3, "a", &dbmodels.Page{}); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatal("second primary key after reopening:", err)
+	}
+
+	bulkLoaded, err := New("collection", "other", Options{Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bulkLoaded.Close()
+	rows := &rowSlice{rows: []testRow{{"b", 1, &dbmodels.Page{}}, {"c", 1, &dbmodels.Page{}}, {"c", 2, &dbmodels.Page{}}}}
+	if err = bulkLoaded.BulkLoad(rows, 1); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatal("bulk load of a duplicate key:", err)
+	}
+}
@@ -0,0 +1,294 @@
+package bptree
+
+import (
+	"bptree/btree"
+	"bptree/dbmodels"
+	"cmp"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+)
+
+// TypedTree is a Tree whose keys are of type K and whose primary keys are of type PK throughout its queries,
+// enumerators and result sets. Keys of another type are rejected at compile time instead of failing the comparisons
+// of the index at runtime. Reading a key or primary key
+// of another type, e.g. one put through the untyped Tree of the same index, returns ErrKeyType.
+type TypedTree[K cmp.Ordered, PK comparable] struct {
+	tree *Tree
+}
+
+// TypedBulkIterator yields the rows of a typed bulk load ordered by key in the order of the index and, within a key,
+// by primary key.
+type TypedBulkIterator[K cmp.Ordered, PK comparable] interface {
+	HasNext() bool
+	Next() (key K, primaryKey PK, page *dbmodels.Page, err error)
+}
+
+// NewTyped opens the index of fieldName in collectionName like New, with keys of type K and primary keys of type
+// PK. Types that are not built in are registered with gob for the pages encoding them.
+func NewTyped[K cmp.Ordered, PK comparable](collectionName string, fieldName string, options Options) (*TypedTree[K, PK], error) {
+	registerType[K]()
+	registerType[PK]()
+
+	tree, err := New(collectionName, fieldName, options)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedTree[K, PK]{tree: tree}, nil
+}
+
+func registerType[T any]() {
+	var zero T
+	if valueType := reflect.TypeOf(zero); valueType != nil && valueType.PkgPath() != "" {
+		gob.Register(zero)
+	}
+}
+
+// Untyped returns the Tree the typed tree reads and writes through.
+func (tree *TypedTree[K, PK]) Untyped() *Tree {
+	return tree.tree
+}
+
+func (tree *TypedTree[K, PK]) Close() error {
+	return tree.tree.Close()
+}
+
+func (tree *TypedTree[K, PK]) Compact() error {
+	return tree.tree.Compact()
+}
+
+func (tree *TypedTree[K, PK]) PageStats() btree.PoolStats {
+	return tree.tree.PageStats()
+}
+
+func (tree *TypedTree[K, PK]) Count() int {
+	return tree.tree.Count()
+}
+
+func (tree *TypedTree[K, PK]) Put(primaryKey PK, key K, page *dbmodels.Page) error {
+	return tree.tree.Put(primaryKey, key, page)
+}
+
+func (tree *TypedTree[K, PK]) Delete(primaryKey PK, key K) (bool, error) {
+	return tree.tree.Delete(primaryKey, key)
+}
+
+func (tree *TypedTree[K, PK]) Update(primaryKey PK, oldKey K, newKey K, page *dbmodels.Page) (bool, error) {
+	return tree.tree.Update(primaryKey, oldKey, newKey, page)
+}
+
+func (tree *TypedTree[K, PK]) BulkLoad(rows TypedBulkIterator[K, PK], fillFactor float64) error {
+	return tree.tree.BulkLoad(&untypedBulkRows[K, PK]{rows: rows}, fillFactor)
+}
+
+func (tree *TypedTree[K, PK]) Get(key K) (map[PK]*dbmodels.Page, bool, error) {
+	rows, exists, err := tree.tree.Get(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	typedRows, err := typedRows[PK](*rows)
+	if err != nil {
+		return nil, false, err
+	}
+	return typedRows, true, nil
+}
+
+func (tree *TypedTree[K, PK]) SeekFirst() (*TypedEnumerator[K, PK], error) {
+	return typedEnumerator[K, PK](tree.tree.SeekFirst())
+}
+
+func (tree *TypedTree[K, PK]) Seek(key K) (*TypedEnumerator[K, PK], error) {
+	return typedEnumerator[K, PK](tree.tree.Seek(key))
+}
+
+func (tree *TypedTree[K, PK]) SeekLast() (*TypedEnumerator[K, PK], error) {
+	return typedEnumerator[K, PK](tree.tree.SeekLast())
+}
+
+func (tree *TypedTree[K, PK]) SeekRange(keyRange TypedKeyRange[K]) (*TypedEnumerator[K, PK], error) {
+	return typedEnumerator[K, PK](tree.tree.SeekRange(keyRange.Untyped()))
+}
+
+func (tree *TypedTree[K, PK]) In(keys []K) (map[PK]*dbmodels.Page, error) {
+	rows, err := tree.tree.In(untypedKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[PK](rows)
+}
+
+func (tree *TypedTree[K, PK]) InSorted(keys []K, limit int, cursor Cursor) ([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], Cursor, error) {
+	tuples, next, err := tree.tree.InSorted(untypedKeys(keys), limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedTuples[K, PK](tuples)
+	return typed, next, err
+}
+
+func (tree *TypedTree[K, PK]) InKeysOf(keys []K) ([]*dbmodels.Page, error) {
+	return tree.tree.InKeysOf(untypedKeys(keys))
+}
+
+func (tree *TypedTree[K, PK]) InAndRelevantKeys(keys []K, relevantKeys map[PK]float64) (map[PK]*dbmodels.Page, error) {
+	rows, err := tree.tree.InAndRelevantKeys(untypedKeys(keys), untypedRelevantKeys(relevantKeys))
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[PK](rows)
+}
+
+func (tree *TypedTree[K, PK]) InAndRelevantKeysSorted(keys []K, relevantKeys map[PK]float64, limit int, cursor Cursor) ([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], Cursor, error) {
+	tuples, next, err := tree.tree.InAndRelevantKeysSorted(untypedKeys(keys), untypedRelevantKeys(relevantKeys), limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedTuples[K, PK](tuples)
+	return typed, next, err
+}
+
+func (tree *TypedTree[K, PK]) Range(keyRange TypedKeyRange[K]) (map[PK]*dbmodels.Page, error) {
+	rows, err := tree.tree.Range(keyRange.Untyped())
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[PK](rows)
+}
+
+func (tree *TypedTree[K, PK]) RangeSorted(keyRange TypedKeyRange[K], limit int, cursor Cursor) ([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], Cursor, error) {
+	tuples, next, err := tree.tree.RangeSorted(keyRange.Untyped(), limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedTuples[K, PK](tuples)
+	return typed, next, err
+}
+
+func (tree *TypedTree[K, PK]) RangeReverseSorted(keyRange TypedKeyRange[K], limit int, cursor Cursor) ([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], Cursor, error) {
+	tuples, next, err := tree.tree.RangeReverseSorted(keyRange.Untyped(), limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedTuples[K, PK](tuples)
+	return typed, next, err
+}
+
+func (tree *TypedTree[K, PK]) RangeAndRelevantKeys(keyRange TypedKeyRange[K], relevantKeys map[PK]float64) (map[PK]*dbmodels.Page, error) {
+	rows, err := tree.tree.RangeAndRelevantKeys(keyRange.Untyped(), untypedRelevantKeys(relevantKeys))
+	if err != nil {
+		return nil, err
+	}
+	return typedRows[PK](rows)
+}
+
+func (tree *TypedTree[K, PK]) RangeAndRelevantKeysSorted(keyRange TypedKeyRange[K], relevantKeys map[PK]float64, limit int, cursor Cursor) ([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], Cursor, error) {
+	tuples, next, err := tree.tree.RangeAndRelevantKeysSorted(keyRange.Untyped(), untypedRelevantKeys(relevantKeys), limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedTuples[K, PK](tuples)
+	return typed, next, err
+}
+
+func (tree *TypedTree[K, PK]) All(limit int, cursor Cursor) ([]*dbmodels.TypedSortParamLocation[K], Cursor, error) {
+	locations, next, err := tree.tree.All(limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedLocations[K](locations)
+	return typed, next, err
+}
+
+func (tree *TypedTree[K, PK]) AllReverse(limit int, cursor Cursor) ([]*dbmodels.TypedSortParamLocation[K], Cursor, error) {
+	locations, next, err := tree.tree.AllReverse(limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	typed, err := typedLocations[K](locations)
+	return typed, next, err
+}
+
+// typedValue converts a key or primary key read from the index to T.
+func typedValue[T any](value any) (T, error) {
+	typed, ok := value.(T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("%w: %T read as %T", ErrKeyType, value, zero)
+	}
+	return typed, nil
+}
+
+func typedRows[PK comparable](rows map[any]*dbmodels.Page) (map[PK]*dbmodels.Page, error) {
+	typed := make(map[PK]*dbmodels.Page, len(rows))
+	for primaryKey, page := range rows {
+		typedPrimaryKey, err := typedValue[PK](primaryKey)
+		if err != nil {
+			return nil, err
+		}
+		typed[typedPrimaryKey] = page
+	}
+	return typed, nil
+}
+
+func typedTuples[K cmp.Ordered, PK comparable](tuples []*dbmodels.PrimaryKeyPageTuple) ([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], error) {
+	typed := make([]*dbmodels.TypedPrimaryKeyPageTuple[K, PK], len(tuples))
+	for i, tuple := range tuples {
+		key := tuple.Key
+		if pointer, ok := key.(*any); ok {
+			// The range queries report the key through the pointer returned by the enumerator
+			key = *pointer
+		}
+		typedKey, err := typedValue[K](key)
+		if err != nil {
+			return nil, err
+		}
+		typedPrimaryKey, err := typedValue[PK](tuple.PrimaryKey)
+		if err != nil {
+			return nil, err
+		}
+		typed[i] = &dbmodels.TypedPrimaryKeyPageTuple[K, PK]{PrimaryKey: typedPrimaryKey, Key: typedKey, Page: tuple.Page}
+	}
+	return typed, nil
+}
+
+func typedLocations[K cmp.Ordered](locations []*dbmodels.SortParamLocation) ([]*dbmodels.TypedSortParamLocation[K], error) {
+	typed := make([]*dbmodels.TypedSortParamLocation[K], len(locations))
+	for i, location := range locations {
+		sortParam, err := typedValue[K](location.SortParam)
+		if err != nil {
+			return nil, err
+		}
+		typed[i] = &dbmodels.TypedSortParamLocation[K]{SortParam: sortParam, Locations: location.Locations}
+	}
+	return typed, nil
+}
+
+func untypedKeys[K cmp.Ordered](keys []K) []any {
+	untyped := make([]any, len(keys))
+	for i, key := range keys {
+		untyped[i] = key
+	}
+	return untyped
+}
+
+func untypedRelevantKeys[PK comparable](relevantKeys map[PK]float64) map[any]float64 {
+	untyped := make(map[any]float64, len(relevantKeys))
+	for primaryKey, relevance := range relevantKeys {
+		untyped[primaryKey] = relevance
+	}
+	return untyped
+}
+
+// untypedBulkRows passes the rows of a typed bulk load on to Tree.BulkLoad.
+type untypedBulkRows[K cmp.Ordered, PK comparable] struct {
+	rows TypedBulkIterator[K, PK]
+}
+
+func (rows *untypedBulkRows[K, PK]) HasNext() bool {
+	return rows.rows.HasNext()
+}
+
+func (rows *untypedBulkRows[K, PK]) Next() (any, any, *dbmodels.Page, error) {
+	key, primaryKey, page, err := rows.rows.Next()
+	return key, primaryKey, page, err
+}
@@ -0,0 +1,75 @@
+package bptree
+
+import (
+	"bptree/dbmodels"
+	"errors"
+	"testing"
+)
+
+func TestTypedTreeReportsKeysOfOtherTypes(t *testing.T) {
+	IndexDirectory = t.TempDir()
+	tree, err := NewTyped[int, string]("collection", "field", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for key, primaryKey := range map[int]string{1: "a", 2: "b", 3: "c"} {
+		if err = tree.Put(primaryKey, key, &dbmodels.Page{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, found, err := tree.Get(2)
+	if err != nil || !found || rows["b"] == nil {
+		t.Fatal(rows, found, err)
+	}
+	typed, _, err := tree.RangeSorted(TypedBetween(1, 3), 10, "")
+	if err != nil || len(typed) != 3 || typed[2].Key != 3 || typed[2].PrimaryKey != "c" {
+		t.Fatal(typed, err)
+	}
+
+	// Rows put through the untyped tree: a primary key of another type under 1 and a key of another type
+	if err = tree.Untyped().Put(4, 1, &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.Untyped().Put("d", "4", &dbmodels.Page{}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]func() error{
+		"Get":         func() error { _, _, err := tree.Get(1); return err },
+		"In":          func() error { _, err := tree.In([]int{2, 1}); return err },
+		"InSorted":    func() error { _, _, err := tree.InSorted([]int{1}, 10, ""); return err },
+		"Range":       func() error { _, err := tree.Range(TypedBetween(1, 2)); return err },
+		"RangeSorted": func() error { _, _, err := tree.RangeSorted(TypedBetween(1, 2), 10, ""); return err },
+		"RangeReverseSorted": func() error {
+			_, _, err := tree.RangeReverseSorted(TypedBetween(1, 2), 10, "")
+			return err
+		},
+		"All":        func() error { _, _, err := tree.All(10, ""); return err },
+		"AllReverse": func() error { _, _, err := tree.AllReverse(10, ""); return err },
+		"SeekFirst": func() error {
+			e, err := tree.SeekFirst()
+			if err != nil {
+				return err
+			}
+			defer e.Close()
+			for e.HasNext() {
+				if _, _, err = e.Next(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrKeyType) {
+			t.Errorf("%s over keys of other types: %v", name, err)
+		}
+	}
+
+	// Keys holding only rows of the tree's types are still read
+	if rows, found, err = tree.Get(3); err != nil || !found || rows["c"] == nil {
+		t.Fatal(rows, found, err)
+	}
+}
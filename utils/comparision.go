@@ -1,43 +1,219 @@
 package utils
 
 import (
+	"bytes"
+	"cmp"
+	"math"
 	"reflect"
 	"strings"
+	"time"
 )
 
+// Compare orders two keys, returning a negative number when a sorts before b, zero when they are the same key and a
+// positive number when a sorts after b. It is a total order over the keys it supports:
+//
+//   - keys of different classes are ordered by class: nil, bools, numbers, strings, byte slices and times
+//   - bools order false before true
+//   - numbers of any int, uint or float type are ordered by their exact value, e.g. int(5) and int64(5) are the same
+//     key and int64 values beyond the precision of a float64 still order correctly against it. NaN sorts before
+//     every other number and all NaNs are the same key, -0 and +0 are the same key
+//   - strings and byte slices are ordered by their bytes, times by their instant
+//
+// Named types are ordered like the type they are defined over. Compare panics for keys of any other type, e.g.
+// structs or pointers, IsKey tells them apart.
 func Compare(a, b interface{}) int {
-
-	switch a.(type) {
-	case bool:
-		if a.(bool) {
-			return 1
-		} else {
-			return -1
-		}
+	// Keys of an index almost always share their type, compare the common ones without reflection
+	switch x := a.(type) {
 	case int:
-		return a.(int) - b.(int)
-	case int32:
-		return (int)(a.(int32) - b.(int32))
+		if y, ok := b.(int); ok {
+			return cmp.Compare(x, y)
+		}
 	case int64:
-		return (int)(a.(int64) - b.(int64))
+		if y, ok := b.(int64); ok {
+			return cmp.Compare(x, y)
+		}
+	case int32:
+		if y, ok := b.(int32); ok {
+			return cmp.Compare(x, y)
+		}
 	case string:
-		return strings.Compare(a.(string), b.(string))
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
 	case float64:
-		sub := a.(float64) - b.(float64)
-		if sub > 0.0 {
-			return 1
-		} else if sub < 0.0 {
-			return -1
+		if y, ok := b.(float64); ok {
+			return cmp.Compare(x, y)
 		}
+	}
+	return compareValues(mustKeyValueOf(a), mustKeyValueOf(b))
+}
+
+// IsKey reports whether key is of a type Compare orders.
+func IsKey(key interface{}) bool {
+	_, ok := keyValueOf(key)
+	return ok
+}
+
+// keyClass ranks the classes of keys ordered by Compare.
+type keyClass int
+
+const (
+	nilClass keyClass = iota
+	boolClass
+	numberClass
+	stringClass
+	bytesClass
+	timeClass
+)
+
+// numberKind tells which field of a keyValue holds a number.
+type numberKind int
+
+const (
+	signedNumber numberKind = iota
+	unsignedNumber
+	floatNumber
+)
+
+// keyValue is a key reduced to its class and the value it is ordered by.
+type keyValue struct {
+	class    keyClass
+	kind     numberKind
+	signed   int64
+	unsigned uint64
+	float    float64
+	bool     bool
+	text     string
+	bytes    []byte
+	time     time.Time
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func mustKeyValueOf(key interface{}) keyValue {
+	value, ok := keyValueOf(key)
+	if !ok {
+		panic("Unsupported Index value type:" + reflect.TypeOf(key).String())
+	}
+	return value
+}
+
+func keyValueOf(key interface{}) (keyValue, bool) {
+	switch typed := key.(type) {
+	case nil:
+		return keyValue{class: nilClass}, true
+	case time.Time:
+		return keyValue{class: timeClass, time: typed}, true
+	case []byte:
+		return keyValue{class: bytesClass, bytes: typed}, true
+	}
+
+	value := reflect.ValueOf(key)
+	switch value.Kind() {
+	case reflect.Bool:
+		return keyValue{class: boolClass, bool: value.Bool()}, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return keyValue{class: numberClass, kind: signedNumber, signed: value.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return keyValue{class: numberClass, kind: unsignedNumber, unsigned: value.Uint()}, true
+	case reflect.Float32, reflect.Float64:
+		return keyValue{class: numberClass, kind: floatNumber, float: value.Float()}, true
+	case reflect.String:
+		return keyValue{class: stringClass, text: value.String()}, true
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return keyValue{class: bytesClass, bytes: value.Bytes()}, true
+		}
+	case reflect.Struct:
+		if value.Type().ConvertibleTo(timeType) {
+			return keyValue{class: timeClass, time: value.Convert(timeType).Interface().(time.Time)}, true
+		}
+	}
+	return keyValue{}, false
+}
+
+func compareValues(x, y keyValue) int {
+	if x.class != y.class {
+		return cmp.Compare(x.class, y.class)
+	}
+
+	switch x.class {
+	case boolClass:
+		return compareBools(x.bool, y.bool)
+	case numberClass:
+		return compareNumbers(x, y)
+	case stringClass:
+		return strings.Compare(x.text, y.text)
+	case bytesClass:
+		return bytes.Compare(x.bytes, y.bytes)
+	case timeClass:
+		return x.time.Compare(y.time)
+	}
+	return 0
+}
+
+func compareBools(x, y bool) int {
+	switch {
+	case x == y:
 		return 0
-	case float32:
-		sub := a.(float32) - b.(float32)
-		if sub > 0.0 {
-			return 1
-		} else if sub < 0.0 {
+	case y:
+		return -1
+	}
+	return 1
+}
+
+func compareNumbers(x, y keyValue) int {
+	if x.kind > y.kind {
+		// Only the pairs with the narrower kind first are compared below
+		return -compareNumbers(y, x)
+	}
+
+	switch {
+	case x.kind == signedNumber && y.kind == signedNumber:
+		return cmp.Compare(x.signed, y.signed)
+	case x.kind == signedNumber && y.kind == unsignedNumber:
+		if x.signed < 0 {
 			return -1
 		}
-		return 0
+		return cmp.Compare(uint64(x.signed), y.unsigned)
+	case x.kind == signedNumber:
+		return -compareFloatToSigned(y.float, x.signed)
+	case x.kind == unsignedNumber && y.kind == unsignedNumber:
+		return cmp.Compare(x.unsigned, y.unsigned)
+	case x.kind == unsignedNumber:
+		return -compareFloatToUnsigned(y.float, x.unsigned)
+	}
+	// cmp.Compare orders NaN first and -0 with +0
+	return cmp.Compare(x.float, y.float)
+}
+
+// compareFloatToSigned compares f with i exactly, without rounding i to the nearest float64.
+func compareFloatToSigned(f float64, i int64) int {
+	switch {
+	case math.IsNaN(f) || f < math.MinInt64:
+		return -1
+	case f >= -math.MinInt64:
+		return 1
+	}
+	whole := math.Trunc(f)
+	if result := cmp.Compare(int64(whole), i); result != 0 {
+		return result
+	}
+	return cmp.Compare(f, whole)
+}
+
+// compareFloatToUnsigned compares f with u exactly, without rounding u to the nearest float64.
+func compareFloatToUnsigned(f float64, u uint64) int {
+	switch {
+	case math.IsNaN(f) || f < 0:
+		return -1
+	case f >= math.MaxUint64:
+		// math.MaxUint64 rounds up to 2^64 as a float64
+		return 1
+	}
+	whole := math.Trunc(f)
+	if result := cmp.Compare(uint64(whole), u); result != 0 {
+		return result
 	}
-	panic("Unsupported Index value type:" + reflect.TypeOf(a).String())
+	return cmp.Compare(f, whole)
 }
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+type celsius float64
+
+type label string
+
+// edgeKeys are keys at the limits of the precision and range of their types.
+var edgeKeys = []any{
+	nil, false, true,
+	0, -1, 1, int8(math.MinInt8), int64(math.MinInt64), int64(math.MaxInt64), int64(1<<53 + 1), int64(-(1<<53 + 1)),
+	uint(0), uint8(math.MaxUint8), uint64(math.MaxUint64), uint64(1 << 63), uint64(1<<53 + 1),
+	0.0, math.Copysign(0, -1), 0.5, -0.5, 1.0, math.NaN(), -math.NaN(), math.Inf(1), math.Inf(-1),
+	float64(1 << 53), float64(1 << 63), -float64(1 << 63), float64(1 << 64), math.MaxFloat64, math.SmallestNonzeroFloat64,
+	float32(0.1), float32(math.NaN()), celsius(0.5), celsius(math.NaN()),
+	"", "a", "A", "ab", "\xff", label("a"),
+	[]byte{}, []byte("a"), []byte("ab"), []byte{0xff},
+	time.Unix(0, 0), time.Unix(0, 1).In(time.FixedZone("east", 3600)), time.Unix(1, 0).UTC(),
+}
+
+// randomKey returns a key of a random supported type, often near one of the others.
+func randomKey(r *rand.Rand) any {
+	switch r.Intn(9) {
+	case 0:
+		return edgeKeys[r.Intn(len(edgeKeys))]
+	case 1:
+		return r.Intn(21) - 10
+	case 2:
+		return int64(r.Uint64())
+	case 3:
+		return r.Uint64() >> r.Intn(64)
+	case 4:
+		return float64(r.Intn(21)-10) / float64(1+r.Intn(4))
+	case 5:
+		return math.Float64frombits(r.Uint64())
+	case 6:
+		return string(rune('a' + r.Intn(3)))
+	case 7:
+		return []byte{byte('a' + r.Intn(3))}
+	}
+	return time.Unix(int64(r.Intn(3)), 0)
+}
+
+func sign(result int) int {
+	return Compare(result, 0)
+}
+
+func TestCompareIsTotalOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keys := slices.Clone(edgeKeys)
+	for len(keys) < 400 {
+		keys = append(keys, randomKey(r))
+	}
+
+	for _, a := range keys {
+		if Compare(a, a) != 0 {
+			t.Fatalf("%#v differs from itself", a)
+		}
+		for _, b := range keys {
+			if sign(Compare(a, b)) != -sign(Compare(b, a)) {
+				t.Fatalf("%#v and %#v compare %d and %d", a, b, Compare(a, b), Compare(b, a))
+			}
+		}
+	}
+
+	// Every pair of a sorted slice must be in order, otherwise the order is not transitive
+	slices.SortFunc(keys, Compare)
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			if Compare(keys[i], keys[j]) > 0 {
+				t.Fatalf("%#v sorted before %#v", keys[i], keys[j])
+			}
+		}
+	}
+	for i := 0; i < 100000; i++ {
+		a, b, c := randomKey(r), randomKey(r), randomKey(r)
+		if Compare(a, b) <= 0 && Compare(b, c) <= 0 && Compare(a, c) > 0 {
+			t.Fatalf("%#v <= %#v <= %#v but %#v > %#v", a, b, c, a, c)
+		}
+		if Compare(a, b) == 0 && sign(Compare(a, c)) != sign(Compare(b, c)) {
+			t.Fatalf("%#v == %#v but they compare differently to %#v", a, b, c)
+		}
+	}
+}
+
+// exactNumber returns the exact value of a number key, nil for NaN.
+func exactNumber(key any) *big.Float {
+	value, _ := keyValueOf(key)
+	switch value.kind {
+	case signedNumber:
+		return new(big.Float).SetInt64(value.signed)
+	case unsignedNumber:
+		return new(big.Float).SetUint64(value.unsigned)
+	}
+	if math.IsNaN(value.float) {
+		return nil
+	}
+	return big.NewFloat(value.float)
+}
+
+func TestCompareOrdersNumbersExactly(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	var numbers []any
+	for _, key := range edgeKeys {
+		if value, _ := keyValueOf(key); value.class == numberClass {
+			numbers = append(numbers, key)
+		}
+	}
+	for len(numbers) < 400 {
+		if key := randomKey(r); mustKeyValueOf(key).class == numberClass {
+			numbers = append(numbers, key)
+		}
+	}
+
+	for _, a := range numbers {
+		for _, b := range numbers {
+			x, y := exactNumber(a), exactNumber(b)
+			var want int
+			switch {
+			case x == nil && y == nil:
+				want = 0
+			case x == nil:
+				want = -1
+			case y == nil:
+				want = 1
+			default:
+				want = x.Cmp(y)
+			}
+			if got := sign(Compare(a, b)); got != want {
+				t.Fatalf("%#v compared to %#v is %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestCompareEdgeCases(t *testing.T) {
+	cases := []struct {
+		a, b any
+		want int
+	}{
+		{int(5), int64(5), 0},
+		{uint8(5), 5.0, 0},
+		{celsius(0.5), 0.5, 0},
+		{label("a"), "a", 0},
+		{math.Copysign(0, -1), 0.0, 0},
+		{math.Copysign(0, -1), 0, 0},
+		{math.NaN(), -math.NaN(), 0},
+		{math.NaN(), math.Inf(-1), -1},
+		{math.NaN(), int64(math.MinInt64), -1},
+		{int64(1<<53 + 1), float64(1 << 53), 1},
+		{uint64(math.MaxUint64), float64(1 << 64), -1},
+		{int64(math.MaxInt64), float64(1 << 63), -1},
+		{int64(-1), uint64(0), -1},
+		{nil, false, -1},
+		{true, math.Inf(-1), -1},
+		{math.Inf(1), "", -1},
+		{"\xff", []byte{}, -1},
+		{[]byte{0xff}, time.Time{}, -1},
+		{"a", []byte("a"), -1},
+		{time.Unix(0, 0), time.Unix(0, 0).In(time.FixedZone("east", 3600)), 0},
+	}
+	for _, c := range cases {
+		if got := sign(Compare(c.a, c.b)); got != c.want {
+			t.Errorf("%#v compared to %#v is %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestIsKey(t *testing.T) {
+	for _, key := range edgeKeys {
+		if !IsKey(key) {
+			t.Errorf("%#v is not a key", key)
+		}
+	}
+	for _, key := range []any{struct{}{}, &struct{}{}, []int{1}, [1]byte{1}, map[string]int{}, func() {}, complex(1, 0)} {
+		if IsKey(key) {
+			t.Errorf("%#v is a key", key)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Compare accepted %#v", key)
+				}
+			}()
+			Compare(key, key)
+		}()
+	}
+}